@@ -1,28 +1,69 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
 
 	"goweb/internal/cache"
 	"goweb/internal/dao"
 	"goweb/internal/di"
+	"goweb/internal/export"
 	"goweb/internal/handler"
+	"goweb/internal/leader"
 
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
 )
 
+func migrateIndex(opts di.ConfOptions) {
+
+	app := fx.New(
+		fx.NopLogger,
+		di.ProvideConfig(opts),
+		di.ProvideLogger(),
+		fx.Provide(dao.NewElasticClient, dao.NewOrderIndexManager),
+		fx.Invoke(func(m *dao.OrderIndexManager) error {
+			return m.Migrate(context.Background())
+		}),
+	)
+
+	if err := app.Err(); err != nil {
+		fmt.Printf("迁移订单索引失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func main() {
 
+	opts, args, err := di.ParseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	if len(args) > 0 && args[0] == "migrate-index" {
+		migrateIndex(opts)
+		return
+	}
+
 	fx.New(
 		fx.WithLogger(func(log *zap.Logger) fxevent.Logger {
 			return &fxevent.ZapLogger{Logger: log}
 		}),
-		di.ProvideConfig(),
+		di.ProvideConfig(opts),
 		di.ProvideLogger(),
 		dao.ProvideOrderDao(),
+		dao.ProvideOrderIndex(),
+		dao.ProvideOrderSync(),
 		cache.ProvideCache(),
+		leader.ProvideElector(),
+		export.ProvideJobManager(),
 		handler.ProvideRouter(),
 		di.ProvideServer(),
 		fx.Invoke(func(*http.Server) {}),
@@ -1,216 +1,497 @@
 package cache
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"math"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"goweb/internal/config"
+	"goweb/internal/leader"
+
 	"github.com/go-redis/redis"
 	"github.com/knadh/koanf"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
-const expireKeySortSet = "expire_key_sort_set"
-const totalHitMap = "total_hit_map"
-
-var rangeScript = redis.NewScript(`local k = redis.call('ZRANGE',KEYS[1],ARGV[1],ARGV[2]) 
-if (#k > 0) then 
-    redis.call('ZINCRBY',KEYS[3],3600,ARGV[3])
-    return {redis.call('HMGET',KEYS[4],KEYS[1]),redis.call('HMGET',KEYS[2],unpack(k))}
-else
-    return {0,k}
-end`)
+const defaultLocalSize = 10000
+const defaultLocalTTL = time.Minute
+
+// rangeScript 按分数查询一页，数据或总数缺失时视为未命中，三个key位于同一slot，
+// 最后返回有序集合的剩余存活时间(毫秒)
+var rangeScript = redis.NewScript(`local k = redis.call('ZRANGEBYSCORE',KEYS[1],ARGV[1],ARGV[2])
+if (#k == 0) then
+    return {false,k}
+end
+return {redis.call('HGET',KEYS[3],'total'),redis.call('HMGET',KEYS[2],unpack(k)),redis.call('HGET',KEYS[3],ARGV[3]),redis.call('PTTL',KEYS[1])}`)
+
+// rangePage 一页缓存，soft为软过期时间(毫秒)，为0表示没有软过期；
+// 一级缓存中的同一页共享refreshing，软过期后只发起一次后台刷新
+type rangePage struct {
+	total      int64
+	record     []string
+	soft       int64
+	refreshing atomic.Bool
+}
 
-type CacheLocation struct {
-	SortSet string
-	Hashmap string
+// startRefresh 已软过期且尚未刷新时返回true，刷新完成后一级缓存失效，读取到新的页
+func (p *rangePage) startRefresh(now int64) bool {
+	return p.soft > 0 && now > p.soft && p.refreshing.CompareAndSwap(false, true)
 }
 
 type Cache struct {
-	lv1Cache any
-	lv2Cache *redis.Client
-	logger   *zap.Logger
+	lv1Cache   *localCache
+	lv2Cache   redis.UniversalClient
+	logger     *zap.Logger
+	instanceId string
+
+	group    singleflight.Group
+	lockTTL  atomic.Int64
+	lockWait atomic.Int64
+
+	janitorInterval time.Duration
+	keyspaceEvents  bool
+	elector         *leader.Elector
+
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pubsubs []*redis.PubSub
+	stats   JanitorStats
+}
+
+func hashLocalPrefix(key string) string {
+	return "h\x00" + key + "\x00"
+}
+
+func hashLocalKey(key, field string) string {
+	return hashLocalPrefix(key) + field
+}
+
+func valueLocalKey(key string) string {
+	return "v\x00" + key
+}
+
+func rangeLocalPrefix(sortKey string) string {
+	return "r\x00" + sortKey + "\x00"
+}
+
+func rangeLocalKey(sortKey string, start, end int64) string {
+	return rangeLocalPrefix(sortKey) + strconv.FormatInt(start, 10) + "\x00" + strconv.FormatInt(end, 10)
+}
+
+func (c *Cache) putLocal(key, field string, value any) {
+	if str, ok := localValue(value); ok {
+		c.lv1Cache.set(hashLocalKey(key, field), str, hashLocalPrefix(key))
+	} else {
+		c.lv1Cache.remove(hashLocalKey(key, field))
+	}
+}
+
+func (c *Cache) Put(key, field string, value any) error {
+	err := c.lv2Cache.HSet(key, field, value).Err()
+
+	// 包含该字段的分页持有旧值，一并失效
+	c.lv1Cache.removeGroup(hashLocalKey(key, field))
+
+	if err != nil {
+		c.lv1Cache.remove(hashLocalKey(key, field))
+		return err
+	}
+
+	c.putLocal(key, field, value)
+	c.publishInvalidate(key, []string{field}, false)
+	return nil
+}
+
+func (c *Cache) Delete(key string, fields ...string) error {
+	var err error
+	if len(fields) > 0 {
+		err = c.lv2Cache.HDel(key, fields...).Err()
+	} else {
+		err = c.lv2Cache.Del(key).Err()
+	}
+
+	c.evictLocal(&invalidateMessage{Key: key, Fields: fields})
+	c.publishInvalidate(key, fields, false)
+
+	return err
+}
+
+// 同一分页的有序集合、数据及元数据以sortKey为hash tag，cluster下位于同一slot，
+// lua脚本及事务可以同时操作
+const (
+	pageSortPrefix = "page_sort:"
+	pageDataPrefix = "page_data:"
+	pageMetaPrefix = "page_meta:"
+)
+
+func pageKey(prefix, sortKey string) string {
+	return prefix + "{" + sortKey + "}"
+}
+
+// pageSortKey 分页的有序集合，成员分数为排名
+func pageSortKey(sortKey string) string {
+	return pageKey(pageSortPrefix, sortKey)
+}
+
+// pageDataKey 分页数据，与有序集合同时过期
+func pageDataKey(sortKey string) string {
+	return pageKey(pageDataPrefix, sortKey)
+}
+
+// pageMetaKey 分页总数及各页软过期时间
+func pageMetaKey(sortKey string) string {
+	return pageKey(pageMetaPrefix, sortKey)
+}
+
+// parsePageKey 从分页的存储key还原sortKey
+func parsePageKey(key, prefix string) (string, bool) {
+	if len(key) < len(prefix)+2 || key[:len(prefix)] != prefix || key[len(prefix)] != '{' || key[len(key)-1] != '}' {
+		return "", false
+	}
+	return key[len(prefix)+1 : len(key)-1], true
+}
+
+func (c *Cache) DeleteRange(sortKey string) error {
+
+	err := c.lv2Cache.Del(pageSortKey(sortKey), pageDataKey(sortKey), pageMetaKey(sortKey)).Err()
+
+	c.lv1Cache.removeGroup(rangeLocalPrefix(sortKey))
+	c.publishInvalidate(sortKey, nil, true)
+
+	return err
 }
 
-func (c *Cache) clearExpireKey() {
+// PutRange 写入分页，sort的分数为排名，有序集合、数据及总数使用同一过期时间
+func (c *Cache) PutRange(sortKey string, sort []redis.Z, data map[string]any, total int64, expire time.Duration) error {
+	if len(sort) == 0 {
+		return nil
+	}
+
+	start, end := sort[0].Score, sort[0].Score
+	for _, z := range sort {
+		start, end = math.Min(start, z.Score), math.Max(end, z.Score)
+	}
+	return c.putRange(sortKey, int64(start), int64(end), sort, data, map[string]any{"total": total}, expire)
+}
 
-	ticker := time.NewTicker(30 * time.Second)
-	for {
-		t := <-ticker.C
+// putRange 替换分数在start到end之间的成员，刷新后的分页不残留旧成员
+func (c *Cache) putRange(sortKey string, start, end int64, sort []redis.Z, data map[string]any, meta map[string]any, expire time.Duration) error {
 
-		now := t.Unix()
-		cmd := c.lv2Cache.ZRange(expireKeySortSet, 0, now)
-		if cmd.Err() != nil {
-			c.logger.Warn("获取缓存失败", zap.String("key", expireKeySortSet), zap.Int64("expireTime", now), zap.Error(cmd.Err()))
-			continue
+	sortSetKey, dataKey, metaKey := pageSortKey(sortKey), pageDataKey(sortKey), pageMetaKey(sortKey)
+	_, err := c.lv2Cache.TxPipelined(func(p redis.Pipeliner) error {
+		p.ZRemRangeByScore(sortSetKey, strconv.FormatInt(start, 10), strconv.FormatInt(end, 10))
+		if len(sort) > 0 {
+			p.ZAdd(sortSetKey, sort...)
 		}
+		if len(data) > 0 {
+			p.HMSet(dataKey, data)
+		}
+		p.HMSet(metaKey, meta)
+		for _, key := range []string{sortSetKey, dataKey, metaKey} {
+			p.Expire(key, expire)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		for _, member := range cmd.Val() {
-			var cacheInfo CacheLocation
-			err := json.Unmarshal([]byte(member), &cacheInfo)
-			if err != nil {
-				c.logger.Warn("解析缓存失败", zap.String("member", member), zap.Error(cmd.Err()))
-				continue
-			}
+	// 有序集合已变化，该查询缓存的分页全部失效
+	c.lv1Cache.removeGroup(rangeLocalPrefix(sortKey))
+	c.publishInvalidate(sortKey, nil, true)
 
-			var cur uint64
-			var keys []string
-			for {
-				cmd := c.lv2Cache.ZScan(cacheInfo.SortSet, cur, "*", 100)
-				if cmd.Err() != nil {
-					c.logger.Warn("获取缓存失败", zap.String("key", cacheInfo.SortSet), zap.Uint64("current", cur), zap.Error(cmd.Err()))
-					continue
-				}
-
-				keys, cur = cmd.Val()
-				count := len(keys)
-
-				if count > 0 {
-					c.lv2Cache.HDel(cacheInfo.Hashmap, keys...)
-				}
-				if count < 100 {
-					break
-				}
-			}
+	return nil
+}
 
-			icmd := c.lv2Cache.HDel(totalHitMap, cacheInfo.SortSet)
-			if icmd.Err() != nil {
-				c.logger.Warn("删除缓存失败", zap.String("key", totalHitMap), zap.String("filed", cacheInfo.SortSet), zap.Error(icmd.Err()))
-				continue
+// scanKeys 在所有主节点上scan，fn串行调用
+func (c *Cache) scanKeys(pattern string, fn func(keys []string) error) error {
+
+	fn = serialize(fn)
+	return forEachMaster(c.lv2Cache, func(node *redis.Client) error {
+		var cur uint64
+		for {
+			keys, next, err := node.Scan(cur, pattern, 100).Result()
+			if err != nil {
+				return err
 			}
 
-			cmd := c.lv2Cache.Del(cacheInfo.SortSet)
-			if cmd.Err() != nil {
-				c.logger.Warn("删除缓存失败", zap.String("key", cacheInfo.SortSet), zap.Error(cmd.Err()))
-				continue
+			if err := fn(keys); err != nil {
+				return err
 			}
 
-			cmd = c.lv2Cache.ZRem(expireKeySortSet, member)
-			if cmd.Err() != nil {
-				c.logger.Warn("删除缓存失败", zap.String("key", expireKeySortSet), zap.String("member", member), zap.Error(cmd.Err()))
-				continue
+			cur = next
+			if cur == 0 {
+				return nil
 			}
 		}
-	}
+	})
 }
 
-func (c *Cache) Put(key, field string, value any) error {
-	return c.lv2Cache.HSet(key, field, value).Err()
+// GetAll 查询hash的全部字段，不经过一级缓存
+func (c *Cache) GetAll(key string) (map[string]string, error) {
+	return c.lv2Cache.HGetAll(key).Result()
 }
 
-func (c *Cache) PutRange(sortKey, dataKey string, sort []redis.Z, data map[string]any, total int64, expire time.Duration) error {
+// GetRemote 查询hash的字段，不经过一级缓存，用于其他节点随时会修改的状态，不存在时返回redis.Nil
+func (c *Cache) GetRemote(key, field string) (string, error) {
+	return c.lv2Cache.HGet(key, field).Result()
+}
 
-	cmds, err := c.lv2Cache.TxPipelined(func(p redis.Pipeliner) error {
-		if err := p.ZAdd(sortKey, sort...).Err(); err != nil {
-			return err
-		}
+// PutValue 写入带过期时间的单值缓存
+func (c *Cache) PutValue(key string, value any, expire time.Duration) error {
+	if err := c.lv2Cache.Set(key, value, expire).Err(); err != nil {
+		c.lv1Cache.remove(valueLocalKey(key))
+		return err
+	}
 
-		member, err := json.Marshal(CacheLocation{SortSet: sortKey, Hashmap: dataKey})
-		if err != nil {
-			return err
+	if str, ok := localValue(value); ok {
+		// 一级缓存不能比redis存活更久
+		ttl := c.lv1Cache.defaultTTL()
+		if expire > 0 && expire < ttl {
+			ttl = expire
 		}
+		c.lv1Cache.setWithTTL(valueLocalKey(key), str, ttl)
+	}
+	c.publishInvalidate(key, nil, false)
 
-		if err := p.ZAdd(expireKeySortSet, redis.Z{Score: float64(time.Now().Add(expire).Unix()), Member: member}).Err(); err != nil {
-			return err
-		}
+	return nil
+}
 
-		if err := p.HMSet(dataKey, data).Err(); err != nil {
-			return err
-		}
+// GetValue 查询单值缓存，不存在时返回redis.Nil
+func (c *Cache) GetValue(key string) (string, error) {
 
-		if err := p.HSet(totalHitMap, sortKey, total).Err(); err != nil {
-			return err
-		}
+	if v, ok := c.lv1Cache.get(valueLocalKey(key)); ok {
+		return v.(string), nil
+	}
 
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := c.lv2Cache.Pipelined(func(p redis.Pipeliner) error {
+		get = p.Get(key)
+		pttl = p.PTTL(key)
 		return nil
 	})
-
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	for _, cmd := range cmds {
-		if cmd.Err() != nil {
-			return err
-		}
+	// 一级缓存不能比redis存活更久
+	ttl := c.lv1Cache.defaultTTL()
+	if remain := pttl.Val(); remain > 0 && remain < ttl {
+		ttl = remain
 	}
+	c.lv1Cache.setWithTTL(valueLocalKey(key), get.Val(), ttl)
 
-	return nil
+	return get.Val(), nil
+}
+
+// Incr 将hash中的各字段加1并失效各实例的一级缓存，可作为缓存版本号，递增后旧版本的缓存不再读取
+func (c *Cache) Incr(key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	_, err := c.lv2Cache.Pipelined(func(p redis.Pipeliner) error {
+		for _, field := range fields {
+			p.HIncrBy(key, field, 1)
+		}
+		return nil
+	})
+
+	c.evictLocal(&invalidateMessage{Key: key, Fields: fields})
+	c.publishInvalidate(key, fields, false)
+
+	return err
 }
 
 func (c *Cache) Get(key, field string) (any, error) {
 
+	if v, ok := c.lv1Cache.get(hashLocalKey(key, field)); ok {
+		return v, nil
+	}
+
 	cmd := c.lv2Cache.HGet(key, field)
 
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
 
+	c.lv1Cache.set(hashLocalKey(key, field), cmd.Val(), hashLocalPrefix(key))
+
 	return cmd.Val(), nil
 }
 
-func (c *Cache) Range(sortKey, dataKey string, start, end int64) (int64, []string, error) {
+func (c *Cache) Range(sortKey string, start, end int64) (int64, []string, error) {
+	page, err := c.rangePage(sortKey, start, end)
+	if err != nil || page == nil {
+		return 0, nil, err
+	}
+	return page.total, page.record, nil
+}
 
-	expireKey, err := json.Marshal(CacheLocation{SortSet: sortKey, Hashmap: dataKey})
+// rangePage 按分数查询一页缓存，未命中时返回nil
+func (c *Cache) rangePage(sortKey string, start, end int64) (*rangePage, error) {
+
+	localKey := rangeLocalKey(sortKey, start, end)
+	if v, ok := c.lv1Cache.get(localKey); ok {
+		return v.(*rangePage), nil
+	}
+
+	ret, err := rangeScript.Run(c.lv2Cache, []string{pageSortKey(sortKey), pageDataKey(sortKey), pageMetaKey(sortKey)}, start, end, softField(start, end)).Result()
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
-	cmd := rangeScript.Run(c.lv2Cache, []string{sortKey, dataKey, expireKeySortSet, totalHitMap}, start, end, expireKey)
+	page, err := parseRange(ret)
+	if err != nil || page == nil {
+		return nil, err
+	}
 
-	if cmd.Err() != nil {
-		return 0, nil, cmd.Err()
-	}
-
-	ret, ok := cmd.Val().([]any)
-
-	var total int64
-	var record []string
-
-	if ok && len(ret) == 2 {
-		ret1, ok1 := ret[0].([]any)
-		ret2, ok2 := ret[1].([]any)
-		if ok1 && ok2 {
-			if len(ret1) == 1 {
-				totalStr, _ := ret1[0].(string)
-				total, err = strconv.ParseInt(totalStr, 10, 0)
-				if err != nil {
-					return total, record, err
-				}
-
-				record = make([]string, len(ret2))
-				for i := range ret2 {
-					str, _ := ret2[i].(string)
-					record[i] = str
-				}
-			}
+	// 一级缓存不能比redis存活更久
+	ttl := c.lv1Cache.defaultTTL()
+	if remain := rangeTTL(ret); remain > 0 && remain < ttl {
+		ttl = remain
+	}
+	c.lv1Cache.setWithTTL(localKey, page, ttl, rangeLocalPrefix(sortKey))
+	return page, nil
+}
+
+// rangeTTL rangeScript返回的分页剩余存活时间，没有过期时间时返回0
+func rangeTTL(ret any) time.Duration {
+	values, _ := ret.([]any)
+	if len(values) < 4 {
+		return 0
+	}
+	pttl, _ := values[3].(int64)
+	return time.Duration(pttl) * time.Millisecond
+}
+
+// parseRange 解析rangeScript的返回值，数据不完整时返回nil
+func parseRange(ret any) (*rangePage, error) {
+
+	values, ok := ret.([]any)
+	if !ok || len(values) < 2 {
+		return nil, nil
+	}
+
+	totalStr, ok := values[0].(string)
+	if !ok {
+		return nil, nil
+	}
+	total, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	records, _ := values[1].([]any)
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	page := &rangePage{total: total, record: make([]string, len(records))}
+	for i, v := range records {
+		str, ok := v.(string)
+		if !ok {
+			return nil, nil
 		}
+		page.record[i] = str
+	}
+
+	if len(values) > 2 {
+		softStr, _ := values[2].(string)
+		page.soft, _ = strconv.ParseInt(softStr, 10, 64)
 	}
-	return total, record, err
+	return page, nil
 }
 
-func NewCache(remoteCache *redis.Client, logger *zap.Logger) *Cache {
-	p := &Cache{
-		lv1Cache: nil,
-		lv2Cache: remoteCache,
-		logger:   logger,
+// cacheTimeouts 一级缓存过期时间、加载锁的过期时间及等待时间
+func cacheTimeouts(k *koanf.Koanf) (ttl, lockTTL, lockWait time.Duration, err error) {
+	ttl, lockTTL, lockWait = defaultLocalTTL, defaultLockTTL, defaultLockWait
+	if k.Exists("cache.local.ttl") {
+		ttl = k.Duration("cache.local.ttl")
+	}
+	if k.Exists("cache.lock.ttl") {
+		lockTTL = k.Duration("cache.lock.ttl")
+	}
+	if k.Exists("cache.lock.wait") {
+		lockWait = k.Duration("cache.lock.wait")
 	}
 
-	go p.clearExpireKey()
+	switch {
+	case ttl <= 0:
+		err = fmt.Errorf("cache.local.ttl必须大于0: %s", k.String("cache.local.ttl"))
+	case lockTTL < time.Millisecond:
+		// SET NX PX以毫秒为单位，为0时锁不会过期
+		err = fmt.Errorf("cache.lock.ttl不能小于1ms: %s", k.String("cache.lock.ttl"))
+	case lockWait < 0:
+		err = fmt.Errorf("cache.lock.wait不能小于0: %s", k.String("cache.lock.wait"))
+	}
+	return
+}
 
-	return p
+// reload 一级缓存过期时间及加载锁的配置支持运行时修改，配置无效时保留当前配置
+func (c *Cache) reload(k *koanf.Koanf) {
+	ttl, lockTTL, lockWait, err := cacheTimeouts(k)
+	if err != nil {
+		c.logger.Warn("缓存配置无效，保留当前配置", zap.Error(err))
+		return
+	}
+
+	c.lv1Cache.setTTL(ttl)
+	c.lockTTL.Store(int64(lockTTL))
+	c.lockWait.Store(int64(lockWait))
 }
 
-func NewRedisClient(k *koanf.Koanf) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", k.String("db.redis.host"), k.Int("db.redis.port")),
-		Password: k.String("db.redis.password"),
-		DB:       k.Int("db.redis.db"),
+func NewCache(k *koanf.Koanf, remoteCache redis.UniversalClient, elector *leader.Elector, lc fx.Lifecycle, logger *zap.Logger) *Cache {
+	size := defaultLocalSize
+	if k.Exists("cache.local.size") {
+		size = k.Int("cache.local.size")
+	}
+
+	p := &Cache{
+		lv1Cache:   newLocalCache(size, defaultLocalTTL),
+		lv2Cache:   remoteCache,
+		logger:     logger.Named("cache"),
+		instanceId: newInstanceId(),
+
+		janitorInterval: defaultJanitorInterval,
+		elector:         elector,
+	}
+	// 启动时配置无效则使用默认值
+	p.lockTTL.Store(int64(defaultLockTTL))
+	p.lockWait.Store(int64(defaultLockWait))
+	p.reload(k)
+
+	if k.Exists("cache.janitor.interval") {
+		p.janitorInterval = k.Duration("cache.janitor.interval")
+	}
+	p.keyspaceEvents = k.Bool("cache.keyspace_events")
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			p.start()
+			return nil
+		},
+		OnStop: p.stop,
 	})
+
+	return p
 }
 
 func ProvideCache() fx.Option {
-	return fx.Provide(NewRedisClient, NewCache)
+	return fx.Options(
+		fx.Provide(NewRedisClient, NewCache),
+		fx.Invoke(func(store *config.Store, c *Cache) {
+			store.Subscribe(c.reload, "cache.local.ttl", "cache.lock")
+		}),
+	)
 }
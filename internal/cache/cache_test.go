@@ -3,34 +3,54 @@ package cache
 import (
 	"fmt"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"goweb/internal/config"
+	"goweb/internal/di"
+	"goweb/internal/leader"
+
 	"github.com/go-redis/redis"
 	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/confmap"
+	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-func prepare() (*redis.Client, *zap.Logger) {
+// nopLifecycle 测试中不启动选举，缓存不运行leader任务
+type nopLifecycle struct{}
+
+func (nopLifecycle) Append(fx.Hook) {}
+
+func prepare() (*koanf.Koanf, redis.UniversalClient, *leader.Elector, *zap.Logger) {
 
 	logger, _ := zap.NewDevelopment()
 
-	var k = koanf.New(".")
-	if err := k.Load(file.Provider("../../config/config.yaml"), yaml.Parser()); err != nil {
-		fmt.Printf("加载配置失败 %v", err)
-		return nil, nil
+	k, err := di.InitConf(di.ConfOptions{})
+	if err != nil {
+		return nil, nil, nil, nil
+	}
+
+	conf, err := config.NewRedisConfig(k)
+	if err != nil {
+		fmt.Printf("加载redis配置失败 %v", err)
+		return nil, nil, nil, nil
 	}
+	rdb := NewRedisClient(conf, nopLifecycle{})
 
-	rdb := NewRedisClient(k)
+	return k, rdb, leader.NewElector(k, rdb, nopLifecycle{}, logger), logger
+}
 
-	return rdb, logger
+func newTestCache() *Cache {
+	k, rdb, elector, logger := prepare()
+	return NewCache(k, rdb, elector, nopLifecycle{}, logger)
 }
 
 func TestPut(t *testing.T) {
 
-	p := NewCache(prepare())
+	p := newTestCache()
 
 	if err := p.Put("test:test:1", "testKey", "testValue"); err != nil {
 		t.Errorf("添加缓存失败 %v", err)
@@ -39,7 +59,7 @@ func TestPut(t *testing.T) {
 
 func TestPutRange(t *testing.T) {
 
-	p := NewCache(prepare())
+	p := newTestCache()
 
 	sortMembers := make([]redis.Z, 10)
 	datamap := make(map[string]any)
@@ -49,7 +69,7 @@ func TestPutRange(t *testing.T) {
 		datamap[strconv.Itoa(i)] = i
 	}
 
-	if err := p.PutRange("test:sortset:2", "test:test:2", sortMembers, datamap, 10, 30*time.Second); err != nil {
+	if err := p.PutRange("test:sortset:2", sortMembers, datamap, 10, 30*time.Second); err != nil {
 		t.Errorf("添加缓存失败 %v", err)
 	}
 
@@ -57,7 +77,7 @@ func TestPutRange(t *testing.T) {
 
 func TestGet(t *testing.T) {
 
-	p := NewCache(prepare())
+	p := newTestCache()
 
 	data, err := p.Get("test:test:1", "testKey")
 
@@ -74,9 +94,9 @@ func TestGet(t *testing.T) {
 
 func TestGetRange(t *testing.T) {
 
-	p := NewCache(prepare())
+	p := newTestCache()
 
-	total, data, err := p.Range("test:sortset:2", "test:test:2", 0, 10)
+	total, data, err := p.Range("test:sortset:2", 0, 10)
 
 	if err != nil {
 		t.Errorf("查询缓存失败 %v", err)
@@ -92,9 +112,9 @@ func TestGetRange(t *testing.T) {
 
 // func TestGetRange2(t *testing.T) {
 
-// 	p := NewCache(prepare())
+// 	p := newTestCache()
 
-// 	_, data, err := p.Range("sortset:order:0:0", "hashmap:order", 0, 10)
+// 	_, data, err := p.Range("sortset:order:0:0", 0, 10)
 
 // 	if err != nil {
 // 		t.Errorf("查询缓存失败 %v", err)
@@ -105,3 +125,169 @@ func TestGetRange(t *testing.T) {
 // 		t.Error("查询缓存结果不一致")
 // 	}
 // }
+
+func TestRangeOrLoad(t *testing.T) {
+
+	p := newTestCache()
+	p.DeleteRange("test:sortset:3")
+
+	var loads int32
+	load := func() (*RangeLoad, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(100 * time.Millisecond)
+		return &RangeLoad{Total: 2, Members: []string{"a", "b"}, Records: []string{"1", "2"}, Source: "test"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			page, err := p.RangeOrLoad("test:sortset:3", 0, 1, 100*time.Millisecond, time.Minute, load)
+			if err != nil || len(page.Records) != 2 {
+				t.Error("加载分页失败", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads != 1 {
+		t.Error("并发请求应只加载一次", loads)
+	}
+
+	// 软过期后返回旧数据并在后台刷新
+	time.Sleep(200 * time.Millisecond)
+	page, err := p.RangeOrLoad("test:sortset:3", 0, 1, time.Minute, time.Minute, load)
+	if err != nil || page.Source != "" {
+		t.Error("软过期后应返回缓存数据", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if atomic.LoadInt32(&loads) != 2 {
+		t.Error("软过期后应在后台刷新一次", loads)
+	}
+}
+
+func TestRangeOrLoadDuringRefresh(t *testing.T) {
+
+	// redis不可用时未命中直接加载，不写入缓存
+	c := &Cache{
+		lv1Cache: newLocalCache(10, time.Minute),
+		lv2Cache: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 50 * time.Millisecond}),
+		logger:   zap.NewNop(),
+	}
+
+	// 模拟同一页正在后台刷新
+	refreshing, done := make(chan struct{}), make(chan struct{})
+	go c.group.Do(refreshKey("test:sortset:4", 0, 1), func() (any, error) {
+		close(refreshing)
+		<-done
+		return nil, nil
+	})
+	<-refreshing
+	defer close(done)
+
+	load := func() (*RangeLoad, error) {
+		return &RangeLoad{Total: 2, Members: []string{"a", "b"}, Records: []string{"1", "2"}, Source: "test"}, nil
+	}
+	loaded := make(chan *RangeLoad)
+	go func() {
+		page, _ := c.RangeOrLoad("test:sortset:4", 0, 1, time.Minute, time.Minute, load)
+		loaded <- page
+	}()
+
+	select {
+	case page := <-loaded:
+		if page == nil || len(page.Records) != 2 {
+			t.Error("刷新期间未命中的请求应自行加载", page)
+		}
+	case <-time.After(time.Second):
+		t.Error("未命中的请求不应等待后台刷新")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+
+	page, err := parseRange([]any{"12", []any{"a", "b"}, "1655294400000"})
+	if err != nil || page == nil || page.total != 12 || len(page.record) != 2 || page.soft != 1655294400000 {
+		t.Error("解析分页失败", page, err)
+	}
+
+	// 总数缺失、成员为空或数据已过期时视为未命中
+	for _, ret := range []any{
+		[]any{nil, []any{}},
+		[]any{nil, []any{"a"}},
+		[]any{"12", []any{"a", nil}},
+	} {
+		if page, _ := parseRange(ret); page != nil {
+			t.Error("不完整的分页应视为未命中", ret)
+		}
+	}
+}
+
+func TestPageKey(t *testing.T) {
+
+	sortKey := "sortset:order:1:abc:json"
+	if key := pageDataKey(sortKey); key != "page_data:{sortset:order:1:abc:json}" {
+		t.Error("分页key应以sortKey为hash tag", key)
+	}
+
+	for _, prefix := range []string{pageSortPrefix, pageDataPrefix, pageMetaPrefix} {
+		if got, ok := parsePageKey(pageKey(prefix, sortKey), prefix); !ok || got != sortKey {
+			t.Error("解析分页key失败", prefix, got)
+		}
+	}
+
+	for _, key := range []string{"page_data:" + sortKey, "page_meta:{" + sortKey + "}", "page_data:{"} {
+		if _, ok := parsePageKey(key, pageDataPrefix); ok {
+			t.Error("不应解析为分页key", key)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+
+	c := &Cache{lv1Cache: newLocalCache(10, defaultLocalTTL), logger: zap.NewNop()}
+
+	k := koanf.New(".")
+	k.Load(confmap.Provider(map[string]any{"cache.local.ttl": "2s", "cache.lock.ttl": "3s", "cache.lock.wait": "1s"}, "."), nil)
+	c.reload(k)
+	if c.lv1Cache.defaultTTL() != 2*time.Second || c.lockTTL.Load() != int64(3*time.Second) || c.lockWait.Load() != int64(time.Second) {
+		t.Fatal("缓存配置未生效")
+	}
+
+	for key, value := range map[string]string{"cache.local.ttl": "0s", "cache.lock.ttl": "0s", "cache.lock.wait": "-1s"} {
+		k := koanf.New(".")
+		k.Load(confmap.Provider(map[string]any{key: value}, "."), nil)
+		c.reload(k)
+		if c.lv1Cache.defaultTTL() != 2*time.Second || c.lockTTL.Load() != int64(3*time.Second) || c.lockWait.Load() != int64(time.Second) {
+			t.Error("配置无效时应保留当前配置", key, value)
+		}
+	}
+}
+
+func TestStartRefresh(t *testing.T) {
+
+	now := time.Now().UnixMilli()
+	if (&rangePage{}).startRefresh(now) || (&rangePage{soft: now + 1000}).startRefresh(now) {
+		t.Error("未软过期的页不应刷新")
+	}
+
+	page := &rangePage{soft: now - 1000}
+	var started atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if page.startRefresh(now) {
+				started.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if started.Load() != 1 {
+		t.Error("软过期的页应只刷新一次", started.Load())
+	}
+}
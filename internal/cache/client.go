@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+
+	"goweb/internal/config"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/fx"
+)
+
+// newUniversalClient 按部署方式创建客户端，配置已在加载时校验
+func newUniversalClient(conf config.RedisConfig) redis.UniversalClient {
+	switch conf.Mode {
+	case config.RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    conf.Master,
+			SentinelAddrs: conf.Nodes(),
+			Password:      conf.Password.Value(),
+			DB:            conf.DB,
+		})
+	case config.RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{Addrs: conf.Nodes(), Password: conf.Password.Value()})
+	default:
+		return redis.NewClient(&redis.Options{Addr: conf.Nodes()[0], Password: conf.Password.Value(), DB: conf.DB})
+	}
+}
+
+// forEachMaster 在每个主节点上执行fn，scan、过期通知等只作用于单个节点的操作需要逐个节点执行；
+// cluster下各节点并发执行
+func forEachMaster(rdb redis.UniversalClient, fn func(node *redis.Client) error) error {
+	switch client := rdb.(type) {
+	case *redis.ClusterClient:
+		return client.ForEachMaster(fn)
+	case *redis.Client:
+		return fn(client)
+	default:
+		return fmt.Errorf("不支持的redis客户端: %T", rdb)
+	}
+}
+
+// serialize fn在多个节点并发执行时串行调用
+func serialize(fn func(keys []string) error) func(keys []string) error {
+	var mu sync.Mutex
+	return func(keys []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return fn(keys)
+	}
+}
+
+func NewRedisClient(conf config.RedisConfig, lc fx.Lifecycle) redis.UniversalClient {
+	rdb := newUniversalClient(conf)
+
+	lc.Append(fx.Hook{OnStop: closeClient(rdb)})
+	return rdb
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 缓存值的序列化方式
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                       { return "msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+// typeChecker 只支持部分类型的序列化方式，创建PageSpec时校验，避免运行时每次序列化失败
+type typeChecker interface {
+	Check(t reflect.Type) error
+}
+
+var protoMessage = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// protoCodec 只支持实现了proto.Message的类型
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "protobuf" }
+
+func (protoCodec) Check(t reflect.Type) error {
+	if t == nil || !t.Implements(protoMessage) {
+		return fmt.Errorf("%v 不是protobuf消息", t)
+	}
+	return nil
+}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T 不是protobuf消息", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T 不是protobuf消息", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Msgpack  Codec = msgpackCodec{}
+	Protobuf Codec = protoCodec{}
+)
+
+var codecs = map[string]Codec{
+	JSON.Name():     JSON,
+	Msgpack.Name():  Msgpack,
+	Protobuf.Name(): Protobuf,
+}
+
+// CodecByName 按名称查找序列化方式
+func CodecByName(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的缓存序列化方式: %s", name)
+	}
+	return codec, nil
+}
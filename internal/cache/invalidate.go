@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const invalidateChannel = "cache:invalidate"
+
+// invalidateMessage 一级缓存失效通知，Fields为空时失效整个key
+type invalidateMessage struct {
+	Source string   `json:"source"`
+	Key    string   `json:"key"`
+	Fields []string `json:"fields,omitempty"`
+	Range  bool     `json:"range,omitempty"`
+}
+
+func newInstanceId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (c *Cache) evictLocal(msg *invalidateMessage) {
+	if msg.Range {
+		c.lv1Cache.removeGroup(rangeLocalPrefix(msg.Key))
+	}
+
+	// 分页同时挂在key和字段的分组下，删除字段时包含该字段的分页一并失效
+	if len(msg.Fields) == 0 {
+		c.lv1Cache.remove(valueLocalKey(msg.Key))
+		c.lv1Cache.removeGroup(hashLocalPrefix(msg.Key))
+		return
+	}
+
+	for _, field := range msg.Fields {
+		c.lv1Cache.remove(hashLocalKey(msg.Key, field))
+		c.lv1Cache.removeGroup(hashLocalKey(msg.Key, field))
+	}
+}
+
+func (c *Cache) publishInvalidate(key string, fields []string, isRange bool) {
+	msg, err := json.Marshal(invalidateMessage{Source: c.instanceId, Key: key, Fields: fields, Range: isRange})
+	if err != nil {
+		c.logger.Warn("序列化失效通知失败", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	if err := c.lv2Cache.Publish(invalidateChannel, msg).Err(); err != nil {
+		c.logger.Warn("发布失效通知失败", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// subscribeInvalidate 处理其他实例的失效通知，pubsub关闭后返回
+func (c *Cache) subscribeInvalidate(pubsub *redis.PubSub) {
+
+	for m := range pubsub.Channel() {
+		var msg invalidateMessage
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+			c.logger.Warn("解析失效通知失败", zap.String("payload", m.Payload), zap.Error(err))
+			continue
+		}
+
+		if msg.Source == c.instanceId {
+			continue
+		}
+
+		c.evictLocal(&msg)
+	}
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictLocal(t *testing.T) {
+
+	c := &Cache{lv1Cache: newLocalCache(10, time.Minute)}
+
+	c.lv1Cache.set(hashLocalKey("hashmap:order", "1"), "1", hashLocalPrefix("hashmap:order"))
+	c.lv1Cache.set(hashLocalKey("hashmap:order", "2"), "2", hashLocalPrefix("hashmap:order"))
+	c.lv1Cache.set(rangeLocalKey("sortset:order:0:0", 0, 9), &rangePage{}, rangeLocalPrefix("sortset:order:0:0"), hashLocalPrefix("hashmap:order"), hashLocalKey("hashmap:order", "1"))
+	c.lv1Cache.set(rangeLocalKey("sortset:order:0:1", 0, 9), &rangePage{}, rangeLocalPrefix("sortset:order:0:1"), hashLocalPrefix("hashmap:order"), hashLocalKey("hashmap:order", "2"))
+
+	c.evictLocal(&invalidateMessage{Key: "hashmap:order", Fields: []string{"1"}})
+	if _, ok := c.lv1Cache.get(hashLocalKey("hashmap:order", "1")); ok {
+		t.Error("指定字段未失效")
+	}
+	if _, ok := c.lv1Cache.get(rangeLocalKey("sortset:order:0:0", 0, 9)); ok {
+		t.Error("包含该字段的分页未失效")
+	}
+	if _, ok := c.lv1Cache.get(hashLocalKey("hashmap:order", "2")); !ok {
+		t.Error("未指定字段被失效")
+	}
+
+	c.evictLocal(&invalidateMessage{Key: "sortset:order:0:1", Range: true})
+	if _, ok := c.lv1Cache.get(rangeLocalKey("sortset:order:0:1", 0, 9)); ok {
+		t.Error("分页缓存未失效")
+	}
+
+	c.evictLocal(&invalidateMessage{Key: "hashmap:order"})
+	if c.lv1Cache.len() != 0 {
+		t.Error("整个key未失效", c.lv1Cache.len())
+	}
+}
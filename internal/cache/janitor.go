@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"goweb/internal/leader"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const defaultJanitorInterval = 10 * time.Minute
+
+// 旧版本按过期时间有序集合轮询清理分页，升级后删除遗留的key
+const (
+	legacyExpireKey = "expire_key_sort_set"
+	legacyTotalKey  = "total_hit_map"
+)
+
+type legacyLocation struct {
+	SortSet string
+	Hashmap string
+}
+
+// keyspaceChannel 需要redis开启notify-keyspace-events Ex
+const keyspaceChannel = "__keyevent@*__:expired"
+
+// cleanLegacy 删除旧版本的分页索引、总数及共享数据hash
+func (c *Cache) cleanLegacy() {
+
+	members, err := c.lv2Cache.ZRange(legacyExpireKey, 0, -1).Result()
+	if err != nil {
+		c.logger.Warn("获取缓存失败", zap.String("key", legacyExpireKey), zap.Error(err))
+		return
+	}
+
+	keys := map[string]struct{}{legacyExpireKey: {}, legacyTotalKey: {}}
+	for _, member := range members {
+		var loc legacyLocation
+		if err := json.Unmarshal([]byte(member), &loc); err != nil {
+			continue
+		}
+		keys[loc.SortSet] = struct{}{}
+		keys[loc.Hashmap] = struct{}{}
+	}
+
+	del := make([]string, 0, len(keys))
+	for key := range keys {
+		del = append(del, key)
+	}
+	if err := c.deleteKeys(del); err != nil {
+		c.logger.Warn("删除缓存失败", zap.Strings("keys", del), zap.Error(err))
+	}
+
+	if len(members) > 0 {
+		c.logger.Info("清理旧版分页缓存", zap.Int("count", len(members)))
+	}
+}
+
+// deleteKeys 逐个删除，cluster下多个key可能不在同一slot
+func (c *Cache) deleteKeys(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := c.lv2Cache.Pipelined(func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			p.Del(key)
+		}
+		return nil
+	})
+	return err
+}
+
+// cleanOrphans 删除有序集合已不存在或没有过期时间的分页数据
+func (c *Cache) cleanOrphans() (int, error) {
+
+	removed := 0
+	var lastErr error
+	for _, prefix := range []string{pageDataPrefix, pageMetaPrefix} {
+		err := c.scanKeys(prefix+"*", func(keys []string) error {
+			for _, key := range keys {
+				sortKey, ok := parsePageKey(key, prefix)
+				if !ok {
+					continue
+				}
+
+				var exists *redis.IntCmd
+				var ttl *redis.DurationCmd
+				if _, err := c.lv2Cache.Pipelined(func(p redis.Pipeliner) error {
+					exists = p.Exists(pageSortKey(sortKey))
+					ttl = p.TTL(key)
+					return nil
+				}); err != nil {
+					return err
+				}
+
+				if exists.Val() > 0 && ttl.Val() >= 0 {
+					continue
+				}
+				if err := c.lv2Cache.Del(key).Err(); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+		if err != nil {
+			c.logger.Warn("清理分页缓存失败", zap.String("prefix", prefix), zap.Error(err))
+			lastErr = err
+		}
+	}
+	return removed, lastErr
+}
+
+// runJanitor 分页缓存依赖redis过期，定期清理异常残留的数据，只在leader上运行，缓存停止时退出
+func (c *Cache) runJanitor(ctx context.Context) {
+
+	if !c.enter() {
+		return
+	}
+	defer c.wg.Done()
+
+	token := leader.TokenFrom(ctx)
+	c.cleanLegacy()
+
+	ticker := time.NewTicker(c.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if ok, err := c.elector.Verify(token); err != nil || !ok {
+			c.logger.Warn("leader已变更，跳过缓存清理", zap.Int64("token", token), zap.Error(err))
+			c.mu.Lock()
+			c.stats.Skipped++
+			c.mu.Unlock()
+			continue
+		}
+
+		start := time.Now()
+		removed, err := c.cleanOrphans()
+		c.recordJanitor(start, removed, err)
+		if removed > 0 {
+			c.logger.Info("清理残留分页缓存", zap.Int("count", removed), zap.Duration("elapsed", time.Since(start)))
+		}
+	}
+}
+
+// onExpired 收到redis过期通知后清理一级缓存，同一分页的其他key过期时间相同，残留由janitor清理
+func (c *Cache) onExpired(key string) {
+	for _, prefix := range []string{pageSortPrefix, pageDataPrefix, pageMetaPrefix} {
+		if sortKey, ok := parsePageKey(key, prefix); ok {
+			c.lv1Cache.removeGroup(rangeLocalPrefix(sortKey))
+			return
+		}
+	}
+
+	c.evictLocal(&invalidateMessage{Key: key})
+}
+
+// checkKeyspaceEvents 不修改redis配置，节点未开启过期通知时只记录警告
+func (c *Cache) checkKeyspaceEvents(node *redis.Client) {
+	conf, err := node.ConfigGet("notify-keyspace-events").Result()
+	if err != nil || len(conf) != 2 {
+		return
+	}
+
+	flags, _ := conf[1].(string)
+	if !strings.Contains(flags, "E") || !strings.ContainsAny(flags, "xA") {
+		c.logger.Warn("redis未开启过期通知，需配置notify-keyspace-events Ex", zap.String("node", node.Options().Addr), zap.String("current", flags))
+	}
+}
+
+// subscribeExpired 处理过期通知，pubsub关闭后返回
+func (c *Cache) subscribeExpired(pubsub *redis.PubSub) {
+	for m := range pubsub.Channel() {
+		c.onExpired(m.Payload)
+	}
+}
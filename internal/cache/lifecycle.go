@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+// JanitorStats 分页缓存清理任务的运行情况
+type JanitorStats struct {
+	Leader      bool      `json:"leader"`
+	Runs        int64     `json:"runs"`
+	Skipped     int64     `json:"skipped"`
+	Removed     int64     `json:"removed"`
+	LastRun     time.Time `json:"lastRun"`
+	LastElapsed int64     `json:"lastElapsedMs"`
+	LastRemoved int       `json:"lastRemoved"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// JanitorStats 查询清理任务的运行情况
+func (c *Cache) JanitorStats() JanitorStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	stats.Leader = c.elector.IsLeader()
+	return stats
+}
+
+func (c *Cache) recordJanitor(start time.Time, removed int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Runs++
+	c.stats.Removed += int64(removed)
+	c.stats.LastRun = start
+	c.stats.LastElapsed = time.Since(start).Milliseconds()
+	c.stats.LastRemoved = removed
+	c.stats.LastError = ""
+	if err != nil {
+		c.stats.LastError = err.Error()
+	}
+}
+
+// enter 登记一个后台任务，缓存已停止时返回false，任务结束时调用c.wg.Done
+func (c *Cache) enter() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
+
+// goTracked 启动后台任务，停止时等待其结束
+func (c *Cache) goTracked(fn func()) {
+	if !c.enter() {
+		return
+	}
+	go func() {
+		defer c.wg.Done()
+		fn()
+	}()
+}
+
+func (c *Cache) start() {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	invalidate := c.lv2Cache.Subscribe(invalidateChannel)
+	c.pubsubs = append(c.pubsubs, invalidate)
+	c.goTracked(func() { c.subscribeInvalidate(invalidate) })
+
+	// 过期通知只在产生事件的节点上发布，cluster下需订阅每个主节点
+	if c.keyspaceEvents {
+		var mu sync.Mutex
+		err := forEachMaster(c.lv2Cache, func(node *redis.Client) error {
+			c.checkKeyspaceEvents(node)
+			pubsub := node.PSubscribe(keyspaceChannel)
+
+			mu.Lock()
+			c.pubsubs = append(c.pubsubs, pubsub)
+			mu.Unlock()
+			c.goTracked(func() { c.subscribeExpired(pubsub) })
+			return nil
+		})
+		if err != nil {
+			c.logger.Warn("订阅过期通知失败", zap.Error(err))
+		}
+	}
+
+	c.elector.Run("cache-janitor", c.runJanitor)
+}
+
+// stop 停止订阅及清理任务，等待后台刷新等进行中的redis操作完成
+func (c *Cache) stop(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+
+	c.cancel()
+	for _, pubsub := range c.pubsubs {
+		if err := pubsub.Close(); err != nil {
+			c.logger.Warn("关闭订阅失败", zap.Error(err))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		stats := c.JanitorStats()
+		c.logger.Info("缓存后台任务已停止", zap.Int64("runs", stats.Runs), zap.Int64("skipped", stats.Skipped), zap.Int64("removed", stats.Removed))
+		return nil
+	case <-ctx.Done():
+		c.logger.Warn("等待缓存后台任务结束超时")
+		return ctx.Err()
+	}
+}
+
+// closeClient redis连接最后关闭，依赖它的选举及缓存先停止
+func closeClient(rdb redis.UniversalClient) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return rdb.Close()
+	}
+}
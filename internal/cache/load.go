@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLockTTL  = 5 * time.Second
+	defaultLockWait = time.Second
+	lockPollDelay   = 50 * time.Millisecond
+)
+
+// unlockScript 只释放自己持有的锁
+var unlockScript = redis.NewScript(`if redis.call('GET',KEYS[1]) == ARGV[1] then
+    return redis.call('DEL',KEYS[1])
+else
+    return 0
+end`)
+
+// RangeLoad 分页加载结果，Members为有序集合的成员，Records为对应的值
+type RangeLoad struct {
+	Total   int64
+	Members []string
+	Records []string
+	// Source 数据来源，命中缓存时为空
+	Source string
+}
+
+type RangeLoader func() (*RangeLoad, error)
+
+// softField 分页元数据中记录该页软过期时间的字段
+func softField(start, end int64) string {
+	return fmt.Sprintf("soft:%d-%d", start, end)
+}
+
+func lockKey(sortKey string, start, end int64) string {
+	return fmt.Sprintf("lock:%s:%d-%d", sortKey, start, end)
+}
+
+// refreshKey 后台刷新合并请求使用的key，与未命中时的加载分开，刷新不返回数据
+func refreshKey(sortKey string, start, end int64) string {
+	return "refresh:" + lockKey(sortKey, start, end)
+}
+
+// tryLock 以SET NX PX获取跨实例的锁，返回释放时使用的token
+func (c *Cache) tryLock(key string) (string, bool, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	ok, err := c.lv2Cache.SetNX(key, token, time.Duration(c.lockTTL.Load())).Result()
+	return token, ok, err
+}
+
+func (c *Cache) unlock(key, token string) {
+	if err := unlockScript.Run(c.lv2Cache, []string{key}, token).Err(); err != nil {
+		c.logger.Warn("释放缓存锁失败", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// putPage 写入一页数据并记录软过期时间
+func (c *Cache) putPage(sortKey string, start, end int64, page *RangeLoad, soft, hard time.Duration) error {
+	if len(page.Members) == 0 {
+		return nil
+	}
+
+	sort := make([]redis.Z, len(page.Members))
+	data := make(map[string]any, len(page.Members))
+	for i, member := range page.Members {
+		sort[i] = redis.Z{Score: float64(start + int64(i)), Member: member}
+		data[member] = page.Records[i]
+	}
+
+	meta := map[string]any{"total": page.Total, softField(start, end): time.Now().Add(soft).UnixMilli()}
+	return c.putRange(sortKey, start, end, sort, data, meta, hard)
+}
+
+// loadPage 加载一页并写入缓存，其他实例持有锁时等待其写入，超时后直接加载
+func (c *Cache) loadPage(sortKey string, start, end int64, soft, hard time.Duration, load RangeLoader) (*RangeLoad, error) {
+
+	key := lockKey(sortKey, start, end)
+	token, locked, err := c.tryLock(key)
+	if err != nil {
+		c.logger.Warn("获取缓存锁失败", zap.String("key", key), zap.Error(err))
+	}
+
+	if !locked && err == nil {
+		for deadline := time.Now().Add(time.Duration(c.lockWait.Load())); time.Now().Before(deadline); {
+			time.Sleep(lockPollDelay)
+			if page, err := c.rangePage(sortKey, start, end); err == nil && page != nil {
+				return &RangeLoad{Total: page.total, Records: page.record}, nil
+			}
+		}
+		c.logger.Debug("等待缓存加载超时", zap.String("key", key))
+	}
+
+	page, err := load()
+	if err != nil {
+		if locked {
+			c.unlock(key, token)
+		}
+		return nil, err
+	}
+
+	if locked {
+		if err := c.putPage(sortKey, start, end, page, soft, hard); err != nil {
+			c.logger.Warn("写入分页缓存失败", zap.String("key", sortKey), zap.Error(err))
+		}
+		c.unlock(key, token)
+	}
+	return page, nil
+}
+
+// refreshPage 软过期后在后台刷新，同一页只有一个实例的一个请求执行加载
+func (c *Cache) refreshPage(sortKey string, start, end int64, soft, hard time.Duration, load RangeLoader) {
+	c.goTracked(func() { c.refresh(sortKey, start, end, soft, hard, load) })
+}
+
+func (c *Cache) refresh(sortKey string, start, end int64, soft, hard time.Duration, load RangeLoader) {
+	key := lockKey(sortKey, start, end)
+
+	c.group.Do(refreshKey(sortKey, start, end), func() (any, error) {
+		token, locked, err := c.tryLock(key)
+		if err != nil || !locked {
+			return nil, err
+		}
+		defer c.unlock(key, token)
+
+		page, err := load()
+		if err != nil {
+			c.logger.Warn("刷新分页缓存失败", zap.String("key", sortKey), zap.Error(err))
+			return nil, err
+		}
+		if err := c.putPage(sortKey, start, end, page, soft, hard); err != nil {
+			c.logger.Warn("写入分页缓存失败", zap.String("key", sortKey), zap.Error(err))
+		}
+		return nil, nil
+	})
+}
+
+// RangeOrLoad 查询一页缓存，未命中时合并并发请求只加载一次；
+// 超过soft后仍返回旧数据并在后台刷新，超过hard后缓存删除
+func (c *Cache) RangeOrLoad(sortKey string, start, end int64, soft, hard time.Duration, load RangeLoader) (*RangeLoad, error) {
+
+	page, err := c.rangePage(sortKey, start, end)
+	if err != nil {
+		c.logger.Warn("查询分页缓存失败", zap.String("key", sortKey), zap.Error(err))
+	}
+
+	if page != nil {
+		// 刷新失败时不再重试，一级缓存过期后重新读取时再刷新
+		if page.startRefresh(time.Now().UnixMilli()) {
+			c.refreshPage(sortKey, start, end, soft, hard, load)
+		}
+		return &RangeLoad{Total: page.total, Records: page.record}, nil
+	}
+
+	v, err, _ := c.group.Do(lockKey(sortKey, start, end), func() (any, error) {
+		return c.loadPage(sortKey, start, end, soft, hard, load)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*RangeLoad), nil
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"container/list"
+	"encoding"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// localCache 进程内一级缓存，按条目数限制容量，LRU淘汰，每个条目独立过期
+type localCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        atomic.Int64
+	ll         *list.List
+	items      map[string]*list.Element
+	// groups 分组到条目的索引，按分组删除时不必遍历全部条目
+	groups map[string]map[*list.Element]struct{}
+}
+
+type localEntry struct {
+	key      string
+	value    any
+	expireAt time.Time
+	groups   []string
+}
+
+func newLocalCache(maxEntries int, ttl time.Duration) *localCache {
+	l := &localCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		groups:     make(map[string]map[*list.Element]struct{}),
+	}
+	l.setTTL(ttl)
+	return l
+}
+
+// defaultTTL 未指定过期时间的条目的存活时间，支持运行时修改
+func (l *localCache) defaultTTL() time.Duration {
+	return time.Duration(l.ttl.Load())
+}
+
+func (l *localCache) setTTL(ttl time.Duration) {
+	l.ttl.Store(int64(ttl))
+}
+
+func (l *localCache) get(key string) (any, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*localEntry)
+	if time.Now().After(entry.expireAt) {
+		l.removeElement(e)
+		return nil, false
+	}
+
+	l.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+func (l *localCache) set(key string, value any, groups ...string) {
+	l.setWithTTL(key, value, l.defaultTTL(), groups...)
+}
+
+// setWithTTL 写入条目并加入指定分组，已存在的条目会先退出原有分组
+func (l *localCache) setWithTTL(key string, value any, ttl time.Duration, groups ...string) {
+	if l.maxEntries <= 0 || ttl <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expireAt := time.Now().Add(ttl)
+	if e, ok := l.items[key]; ok {
+		entry := e.Value.(*localEntry)
+		l.unlink(e)
+		entry.value = value
+		entry.expireAt = expireAt
+		entry.groups = groups
+		l.link(e)
+		l.ll.MoveToFront(e)
+		return
+	}
+
+	e := l.ll.PushFront(&localEntry{key: key, value: value, expireAt: expireAt, groups: groups})
+	l.items[key] = e
+	l.link(e)
+
+	for l.ll.Len() > l.maxEntries {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *localCache) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.items[key]; ok {
+		l.removeElement(e)
+	}
+}
+
+// removeGroup 删除分组内的全部条目
+func (l *localCache) removeGroup(group string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for e := range l.groups[group] {
+		l.removeElement(e)
+	}
+}
+
+func (l *localCache) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.ll.Len()
+}
+
+func (l *localCache) removeElement(e *list.Element) {
+	l.unlink(e)
+	l.ll.Remove(e)
+	delete(l.items, e.Value.(*localEntry).key)
+}
+
+func (l *localCache) link(e *list.Element) {
+	for _, group := range e.Value.(*localEntry).groups {
+		members, ok := l.groups[group]
+		if !ok {
+			members = make(map[*list.Element]struct{})
+			l.groups[group] = members
+		}
+		members[e] = struct{}{}
+	}
+}
+
+func (l *localCache) unlink(e *list.Element) {
+	for _, group := range e.Value.(*localEntry).groups {
+		members := l.groups[group]
+		delete(members, e)
+		if len(members) == 0 {
+			delete(l.groups, group)
+		}
+	}
+}
+
+// localValue 按redis客户端的序列化规则转换为字符串，保证一级缓存与redis读出的值一致
+func localValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case int:
+		return strconv.FormatInt(int64(v), 10), true
+	case int8:
+		return strconv.FormatInt(int64(v), 10), true
+	case int16:
+		return strconv.FormatInt(int64(v), 10), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLocalCacheEvict(t *testing.T) {
+
+	l := newLocalCache(3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		l.set(strconv.Itoa(i), i)
+	}
+
+	// 访问0使其成为最近使用，再写入新条目时应淘汰1
+	if _, ok := l.get("0"); !ok {
+		t.Error("缓存条目不存在")
+	}
+	l.set("3", 3)
+
+	if _, ok := l.get("1"); ok {
+		t.Error("最久未使用的条目未被淘汰")
+	}
+
+	if l.len() != 3 {
+		t.Error("缓存条目数量超出限制", l.len())
+	}
+}
+
+func TestLocalCacheExpire(t *testing.T) {
+
+	l := newLocalCache(10, time.Minute)
+
+	l.setWithTTL("key", "value", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := l.get("key"); ok {
+		t.Error("过期条目仍可读取")
+	}
+
+	if l.len() != 0 {
+		t.Error("过期条目未被删除", l.len())
+	}
+}
+
+func TestLocalCacheRemoveGroup(t *testing.T) {
+
+	l := newLocalCache(10, time.Minute)
+
+	l.set(rangeLocalKey("sortset:order:1", 0, 9), &rangePage{}, rangeLocalPrefix("sortset:order:1"), hashLocalKey("hashmap:order", "1"))
+	l.set(rangeLocalKey("sortset:order:1", 10, 19), &rangePage{}, rangeLocalPrefix("sortset:order:1"), hashLocalKey("hashmap:order", "2"))
+	l.set(rangeLocalKey("sortset:order:10", 0, 9), &rangePage{}, rangeLocalPrefix("sortset:order:10"), hashLocalKey("hashmap:order", "2"))
+
+	l.removeGroup(rangeLocalPrefix("sortset:order:1"))
+
+	if l.len() != 1 {
+		t.Error("按分组删除结果不一致", l.len())
+	}
+
+	// 页内记录对应的字段被修改时，包含该字段的分页也要删除
+	l.removeGroup(hashLocalKey("hashmap:order", "2"))
+
+	if l.len() != 0 || len(l.groups) != 0 {
+		t.Error("按字段删除分页结果不一致", l.len(), len(l.groups))
+	}
+}
+
+func TestLocalValue(t *testing.T) {
+
+	cases := map[any]string{
+		"str":     "str",
+		12:        "12",
+		uint64(7): "7",
+		1.5:       "1.5",
+		true:      "1",
+		int64(-3): "-3",
+	}
+
+	for v, want := range cases {
+		if got, ok := localValue(v); !ok || got != want {
+			t.Errorf("转换结果不一致 %v: %q", v, got)
+		}
+	}
+
+	if got, ok := localValue([]byte("bytes")); !ok || got != "bytes" {
+		t.Errorf("转换结果不一致 %q", got)
+	}
+}
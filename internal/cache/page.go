@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Key 以冒号拼接缓存key
+func Key(parts ...any) string {
+	s := make([]string, len(parts))
+	for i, part := range parts {
+		s[i] = fmt.Sprint(part)
+	}
+	return strings.Join(s, ":")
+}
+
+// PageSpec 一类分页缓存的存储方式，Id为数据在有序集合中的成员
+type PageSpec[T any] struct {
+	Codec Codec
+	Id    func(item T) string
+	Soft  time.Duration
+	Hard  time.Duration
+}
+
+// NewPageSpec 序列化方式不支持T时返回错误
+func NewPageSpec[T any](codec Codec, id func(item T) string, soft, hard time.Duration) (*PageSpec[T], error) {
+	if checker, ok := codec.(typeChecker); ok {
+		if err := checker.Check(reflect.TypeOf((*T)(nil)).Elem()); err != nil {
+			return nil, fmt.Errorf("缓存序列化方式%s不支持该类型: %w", codec.Name(), err)
+		}
+	}
+	return &PageSpec[T]{Codec: codec, Id: id, Soft: soft, Hard: hard}, nil
+}
+
+// key 不同序列化方式的数据分别存放，key带有序列化方式后缀
+func (s *PageSpec[T]) key(sortKey string) string {
+	return Key(sortKey, s.Codec.Name())
+}
+
+// Page 一页数据，Source为空表示来自缓存
+type Page[T any] struct {
+	Total  int64
+	Items  []T
+	Source string
+}
+
+type PageLoader[T any] func() (total int64, items []T, source string, err error)
+
+// decode 解码一条数据，T为指针时先分配指向的值，protobuf等需要非nil的消息
+func (s *PageSpec[T]) decode(data string) (T, error) {
+	var item T
+	target := any(&item)
+	if t := reflect.TypeOf(item); t != nil && t.Kind() == reflect.Pointer {
+		item = reflect.New(t.Elem()).Interface().(T)
+		target = item
+	}
+	err := s.Codec.Unmarshal([]byte(data), target)
+	return item, err
+}
+
+// GetOrLoadPage 按排名范围读取一页，未命中或软过期时通过load加载，
+// 序列化、排名分数、总数及过期时间由spec处理；任一条数据序列化失败时返回错误，不缓存缺少数据的分页
+func GetOrLoadPage[T any](c *Cache, spec *PageSpec[T], sortKey string, start, end int64, load PageLoader[T]) (*Page[T], error) {
+
+	key := spec.key(sortKey)
+	ret, err := c.RangeOrLoad(key, start, end, spec.Soft, spec.Hard, func() (*RangeLoad, error) {
+		total, items, source, err := load()
+		if err != nil {
+			return nil, err
+		}
+
+		page := &RangeLoad{Total: total, Source: source, Members: make([]string, len(items)), Records: make([]string, len(items))}
+		for i, item := range items {
+			data, err := spec.Codec.Marshal(item)
+			if err != nil {
+				return nil, fmt.Errorf("序列化缓存失败(%s): %w", spec.Codec.Name(), err)
+			}
+			page.Members[i] = spec.Id(item)
+			page.Records[i] = string(data)
+		}
+		return page, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Total: ret.Total, Items: make([]T, len(ret.Records)), Source: ret.Source}
+	for i, record := range ret.Records {
+		if page.Items[i], err = spec.decode(record); err != nil {
+			// 缓存数据无法解析时删除该查询的分页，下次请求重新加载
+			if err := c.DeleteRange(key); err != nil {
+				c.logger.Warn("删除分页缓存失败", zap.String("key", key), zap.Error(err))
+			}
+			return nil, fmt.Errorf("反序列化缓存失败(%s): %w", spec.Codec.Name(), err)
+		}
+	}
+	return page, nil
+}
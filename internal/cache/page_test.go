@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testItem struct {
+	Id     string
+	Amount float64 `json:",string"`
+	Time   time.Time
+}
+
+func TestPageSpecCodec(t *testing.T) {
+
+	item := &testItem{Id: "1", Amount: 12.5, Time: time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)}
+
+	for _, codec := range []Codec{JSON, Msgpack} {
+		spec, err := NewPageSpec(codec, func(i *testItem) string { return i.Id }, time.Minute, time.Hour)
+		if err != nil {
+			t.Fatal(codec.Name(), err)
+		}
+		if spec.key("test") != "test:"+codec.Name() {
+			t.Error("缓存key不一致", spec.key("test"))
+		}
+
+		data, err := codec.Marshal(item)
+		if err != nil {
+			t.Fatal(codec.Name(), err)
+		}
+
+		got, err := spec.decode(string(data))
+		if err != nil || got.Id != item.Id || got.Amount != item.Amount || !got.Time.Equal(item.Time) {
+			t.Error("反序列化结果不一致", codec.Name(), got, err)
+		}
+	}
+
+	// 非指针类型
+	spec, err := NewPageSpec(JSON, func(i testItem) string { return i.Id }, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := spec.decode(`{"Id":"2","Amount":"1"}`); err != nil || got.Id != "2" || got.Amount != 1 {
+		t.Error("反序列化结果不一致", got, err)
+	}
+
+	proto, err := NewPageSpec(Protobuf, func(v *wrapperspb.StringValue) string { return v.Value }, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Protobuf.Marshal(wrapperspb.String("订单"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := proto.decode(string(data)); err != nil || got.Value != "订单" {
+		t.Error("protobuf反序列化结果不一致", got, err)
+	}
+	if _, err := Protobuf.Marshal(item); err == nil {
+		t.Error("非protobuf消息应返回错误")
+	}
+	if _, err := NewPageSpec(Protobuf, func(i *testItem) string { return i.Id }, time.Minute, time.Hour); err == nil {
+		t.Error("序列化方式不支持的类型应在创建时返回错误")
+	}
+
+	if _, err := CodecByName("xml"); err == nil {
+		t.Error("不支持的序列化方式应返回错误")
+	}
+}
+
+func TestKey(t *testing.T) {
+	if key := Key("sortset", "order", uint64(1), "abc"); key != "sortset:order:1:abc" {
+		t.Error("key不一致", key)
+	}
+}
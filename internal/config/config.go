@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"goweb/internal/build"
+
+	"github.com/knadh/koanf"
+	"go.uber.org/fx"
+	"go.uber.org/zap/zapcore"
+)
+
+// ServerConfig http服务及节点配置
+type ServerConfig struct {
+	Host string `koanf:"host"`
+	Port int    `koanf:"port"`
+	// Node 订单号中的节点编号
+	Node  int64       `koanf:"node"`
+	Admin AdminConfig `koanf:"admin"`
+}
+
+// AdminConfig 管理接口令牌，为空时拒绝所有管理请求
+type AdminConfig struct {
+	Token Secret `koanf:"token"`
+}
+
+func (c ServerConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+func (c ServerConfig) Validate() error {
+	return validPort("server.port", c.Port)
+}
+
+// TidbConfig 数据库连接配置
+type TidbConfig struct {
+	Host     string `koanf:"host"`
+	Port     int    `koanf:"port"`
+	User     string `koanf:"user"`
+	Password Secret `koanf:"password"`
+	DB       string `koanf:"db"`
+}
+
+// DSN clientFoundRows使RowsAffected返回匹配行数，乐观锁据此判断是否冲突
+func (c TidbConfig) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&clientFoundRows=true", c.User, c.Password.Value(), c.Host, c.Port, c.DB)
+}
+
+func (c TidbConfig) Validate() error {
+	if c.Host == "" {
+		return missing("db.tidb.host")
+	}
+	if c.DB == "" {
+		return missing("db.tidb.db")
+	}
+	return validPort("db.tidb.port", c.Port)
+}
+
+// EsConfig elastic连接配置，Address可配置为列表或逗号分隔的字符串
+type EsConfig struct {
+	Address  []string      `koanf:"address"`
+	User     string        `koanf:"user"`
+	Password Secret        `koanf:"password"`
+	Timeout  time.Duration `koanf:"timeout"`
+	Breaker  BreakerConfig `koanf:"breaker"`
+	// Migrate 启动时迁移订单索引
+	Migrate bool `koanf:"migrate"`
+}
+
+// BreakerConfig elastic熔断，连续失败Failures次后熔断Open时长
+type BreakerConfig struct {
+	Failures int           `koanf:"failures"`
+	Open     time.Duration `koanf:"open"`
+}
+
+func (c EsConfig) Validate() error {
+	if len(c.Address) == 0 {
+		return missing("db.es.address")
+	}
+	for _, addr := range c.Address {
+		if addr == "" {
+			return fmt.Errorf("配置项db.es.address包含空地址")
+		}
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("配置项db.es.timeout必须大于0: %s", c.Timeout)
+	}
+	if c.Breaker.Failures <= 0 {
+		return fmt.Errorf("配置项db.es.breaker.failures必须大于0: %d", c.Breaker.Failures)
+	}
+	return nil
+}
+
+// redis部署方式
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// RedisConfig redis连接配置，Addrs为sentinel或cluster的种子节点，未配置时使用Host及Port
+type RedisConfig struct {
+	Mode     string   `koanf:"mode"`
+	Host     string   `koanf:"host"`
+	Port     int      `koanf:"port"`
+	Addrs    []string `koanf:"addrs"`
+	Master   string   `koanf:"master"`
+	Password Secret   `koanf:"password"`
+	DB       int      `koanf:"db"`
+}
+
+func (c RedisConfig) Nodes() []string {
+	if len(c.Addrs) > 0 {
+		return c.Addrs
+	}
+	return []string{fmt.Sprintf("%s:%d", c.Host, c.Port)}
+}
+
+func (c RedisConfig) Validate() error {
+	switch c.Mode {
+	case RedisStandalone:
+	case RedisSentinel:
+		if c.Master == "" {
+			return fmt.Errorf("sentinel模式需配置db.redis.master")
+		}
+	case RedisCluster:
+		if c.DB != 0 {
+			return fmt.Errorf("cluster模式不支持db.redis.db")
+		}
+	default:
+		return fmt.Errorf("不支持的redis模式: %s", c.Mode)
+	}
+
+	if len(c.Addrs) == 0 {
+		if c.Host == "" {
+			return missing("db.redis.host")
+		}
+		return validPort("db.redis.port", c.Port)
+	}
+	return nil
+}
+
+// LogConfig 日志配置，Modules为各模块单独的级别，如cache、dao、handler、gorm，级别支持运行时修改
+type LogConfig struct {
+	File    string                   `koanf:"file"`
+	Level   zapcore.Level            `koanf:"level"`
+	Modules map[string]zapcore.Level `koanf:"modules"`
+}
+
+func (c LogConfig) Validate() error {
+	if c.File == "" {
+		return missing("log.file")
+	}
+	return nil
+}
+
+func missing(key string) error {
+	return fmt.Errorf("缺少配置项%s", key)
+}
+
+func validPort(key string, port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("配置项%s端口无效: %d", key, port)
+	}
+	return nil
+}
+
+// load 以默认值为基础解析path下的配置并校验
+func load[T interface{ Validate() error }](k *koanf.Koanf, path string, c T) (T, error) {
+	if err := k.Unmarshal(path, &c); err != nil {
+		return c, fmt.Errorf("解析配置项%s失败: %w", path, err)
+	}
+	return c, c.Validate()
+}
+
+func NewServerConfig(k *koanf.Koanf) (ServerConfig, error) {
+	return load(k, "server", ServerConfig{Host: "0.0.0.0", Port: 8080})
+}
+
+func NewTidbConfig(k *koanf.Koanf) (TidbConfig, error) {
+	return load(k, "db.tidb", TidbConfig{Port: 4000})
+}
+
+func NewEsConfig(k *koanf.Koanf) (EsConfig, error) {
+	return load(k, "db.es", EsConfig{
+		Timeout: 2 * time.Second,
+		Breaker: BreakerConfig{Failures: 5, Open: 30 * time.Second},
+	})
+}
+
+func NewRedisConfig(k *koanf.Koanf) (RedisConfig, error) {
+	return load(k, "db.redis", RedisConfig{Mode: RedisStandalone, Port: 6379})
+}
+
+func NewLogConfig(k *koanf.Koanf) (LogConfig, error) {
+	level := zapcore.InfoLevel
+	if build.Debug {
+		level = zapcore.DebugLevel
+	}
+	return load(k, "log", LogConfig{Level: level})
+}
+
+func ProvideConfig() fx.Option {
+	return fx.Provide(NewServerConfig, NewTidbConfig, NewEsConfig, NewRedisConfig, NewLogConfig)
+}
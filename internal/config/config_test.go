@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/providers/confmap"
+)
+
+func newKoanf(values map[string]any) *koanf.Koanf {
+	k := koanf.New(".")
+	k.Load(confmap.Provider(values, "."), nil)
+	return k
+}
+
+func TestEsConfig(t *testing.T) {
+
+	conf, err := NewEsConfig(newKoanf(map[string]any{"db.es.address": "http://a:9200,http://b:9200", "db.es.timeout": "5s"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Address) != 2 || conf.Timeout != 5*time.Second {
+		t.Error("解析elastic配置失败", conf)
+	}
+	if conf.Breaker.Failures != 5 || conf.Breaker.Open != 30*time.Second {
+		t.Error("未配置时应使用默认值", conf.Breaker)
+	}
+
+	if _, err := NewEsConfig(newKoanf(map[string]any{"db.es.user": "elastic"})); err == nil || !strings.Contains(err.Error(), "db.es.address") {
+		t.Error("缺少elastic地址时应报错", err)
+	}
+}
+
+func TestRedisConfig(t *testing.T) {
+
+	conf, err := NewRedisConfig(newKoanf(map[string]any{"db.redis.host": "127.0.0.1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Mode != RedisStandalone || conf.Nodes()[0] != "127.0.0.1:6379" {
+		t.Error("解析redis配置失败", conf)
+	}
+
+	for _, values := range []map[string]any{
+		{"db.redis.mode": "sentinel", "db.redis.addrs": []string{"a:26379"}},
+		{"db.redis.mode": "cluster", "db.redis.addrs": []string{"a:6379"}, "db.redis.db": 1},
+		{"db.redis.mode": "replica", "db.redis.host": "127.0.0.1"},
+		{"db.redis.host": "127.0.0.1", "db.redis.port": 0},
+	} {
+		if _, err := NewRedisConfig(newKoanf(values)); err == nil {
+			t.Error("无效的redis配置未报错", values)
+		}
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+
+	conf, err := NewServerConfig(newKoanf(map[string]any{"server.port": "9000", "server.admin.token": "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Addr() != "0.0.0.0:9000" || conf.Admin.Token != "secret" {
+		t.Error("解析服务配置失败", conf)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/providers/confmap"
+)
+
+const redacted = "******"
+
+// Secret 密码等敏感配置，打印、序列化时隐藏，使用Value获取原值；
+// 解析后的密钥在koanf中也是Secret，k.String只能得到隐藏后的值，需通过类型化配置读取
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+// MarshalText json、yaml序列化时隐藏
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// SecretResolver 解析密钥引用，ref为去掉scheme:前缀的部分
+type SecretResolver func(ref string) (string, error)
+
+var (
+	resolverMu sync.RWMutex
+	resolvers  = map[string]SecretResolver{
+		"file": resolveFile,
+		"env":  resolveEnv,
+	}
+)
+
+// RegisterResolver 注册scheme对应的密钥来源，如vault
+func RegisterResolver(scheme string, r SecretResolver) {
+	resolverMu.Lock()
+	defer resolverMu.Unlock()
+	resolvers[scheme] = r
+}
+
+func resolver(scheme string) (SecretResolver, bool) {
+	resolverMu.RLock()
+	defer resolverMu.RUnlock()
+	r, ok := resolvers[scheme]
+	return r, ok
+}
+
+// resolveFile file:///run/secrets/tidb，忽略文件末尾的换行
+func resolveFile(ref string) (string, error) {
+	b, err := os.ReadFile(strings.TrimPrefix(ref, "//"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// resolveEnv env:TIDB_PASSWORD
+func resolveEnv(ref string) (string, error) {
+	v, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("环境变量%s不存在", ref)
+	}
+	return v, nil
+}
+
+// ResolveSecrets 将scheme:ref形式的配置值替换为解析出的密钥，替换后的值为Secret，打印配置时隐藏
+func ResolveSecrets(k *koanf.Koanf) error {
+	resolved := make(map[string]any)
+	for key, v := range k.All() {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		scheme, ref, ok := strings.Cut(s, ":")
+		if !ok {
+			continue
+		}
+		r, ok := resolver(scheme)
+		if !ok {
+			continue
+		}
+
+		secret, err := r(ref)
+		if err != nil {
+			return fmt.Errorf("解析配置项%s的密钥失败: %w", key, err)
+		}
+		resolved[key] = Secret(secret)
+	}
+
+	if len(resolved) == 0 {
+		return nil
+	}
+	return k.Load(confmap.Provider(resolved, "."), nil)
+}
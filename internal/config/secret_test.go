@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "tidb")
+	os.WriteFile(path, []byte("tidb-secret\n"), 0600)
+	t.Setenv("TEST_ES_PASSWORD", "es-secret")
+
+	k := newKoanf(map[string]any{
+		"db.tidb.host":     "127.0.0.1",
+		"db.tidb.db":       "goweb",
+		"db.tidb.password": "file://" + path,
+		"db.es.address":    "http://127.0.0.1:9200",
+		"db.es.password":   "env:TEST_ES_PASSWORD",
+	})
+	if err := ResolveSecrets(k); err != nil {
+		t.Fatal(err)
+	}
+
+	tidb, err := NewTidbConfig(k)
+	if err != nil || tidb.Password.Value() != "tidb-secret" {
+		t.Error("解析文件密钥失败", tidb.Password.Value(), err)
+	}
+	es, err := NewEsConfig(k)
+	if err != nil || es.Password.Value() != "es-secret" {
+		t.Error("解析环境变量密钥失败", es.Password.Value(), err)
+	}
+	if es.Address[0] != "http://127.0.0.1:9200" {
+		t.Error("未注册的scheme不应解析", es.Address)
+	}
+
+	// 打印配置时隐藏密钥
+	b, _ := json.Marshal(tidb)
+	for _, dump := range []string{fmt.Sprintf("%v", tidb), fmt.Sprintf("%+v", es), fmt.Sprintf("%#v", tidb), string(b), k.Sprint()} {
+		if strings.Contains(dump, "tidb-secret") || strings.Contains(dump, "es-secret") {
+			t.Error("密钥未隐藏", dump)
+		}
+	}
+
+	if err := ResolveSecrets(newKoanf(map[string]any{"db.redis.password": "env:TEST_MISSING_PASSWORD"})); err == nil || !strings.Contains(err.Error(), "db.redis.password") {
+		t.Error("密钥不存在时应报错", err)
+	}
+}
+
+func TestRegisterResolver(t *testing.T) {
+
+	RegisterResolver("test", func(ref string) (string, error) { return "resolved-" + ref, nil })
+
+	k := newKoanf(map[string]any{"db.redis.password": "test:redis"})
+	if err := ResolveSecrets(k); err != nil {
+		t.Fatal(err)
+	}
+	if conf, _ := NewRedisConfig(k); conf.Password.Value() != "resolved-redis" {
+		t.Error("自定义密钥来源未生效", conf.Password.Value())
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/knadh/koanf"
+	"go.uber.org/zap"
+)
+
+type subscriber struct {
+	keys []string
+	fn   func(k *koanf.Koanf)
+}
+
+// Store 当前生效的配置，重新加载时原子替换并通知订阅者
+type Store struct {
+	current atomic.Pointer[koanf.Koanf]
+	logger  *zap.Logger
+
+	mu   sync.Mutex
+	subs []subscriber
+}
+
+// Koanf 当前生效的配置，调用方不应修改
+func (s *Store) Koanf() *koanf.Koanf {
+	return s.current.Load()
+}
+
+// Subscribe keys为支持运行时修改的配置项，包含其子项，任一项变化时以新配置调用fn
+func (s *Store) Subscribe(fn func(k *koanf.Koanf), keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, subscriber{keys: keys, fn: fn})
+}
+
+func matchKey(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffKeys 新旧配置中值不同的配置项
+func diffKeys(old, new *koanf.Koanf) []string {
+	before, after := old.All(), new.All()
+
+	var changed []string
+	for key, v := range after {
+		if w, ok := before[key]; !ok || !reflect.DeepEqual(v, w) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Update 替换配置并通知相关订阅者，没有订阅者的配置项需重启生效，只记录警告；返回变化的配置项
+func (s *Store) Update(k *koanf.Koanf) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := diffKeys(s.current.Load(), k)
+	if len(changed) == 0 {
+		return nil
+	}
+	s.current.Store(k)
+
+	dynamic := make(map[string]bool, len(changed))
+	for _, sub := range s.subs {
+		notify := false
+		for _, key := range changed {
+			if matchKey(key, sub.keys) {
+				dynamic[key] = true
+				notify = true
+			}
+		}
+		if notify {
+			sub.fn(k)
+		}
+	}
+
+	for _, key := range changed {
+		if !dynamic[key] {
+			s.logger.Warn("配置项不支持运行时修改，重启后生效", zap.String("key", key))
+		}
+	}
+	s.logger.Info("配置已重新加载", zap.Strings("changed", changed))
+	return changed
+}
+
+func NewStore(k *koanf.Koanf, logger *zap.Logger) *Store {
+	s := &Store{logger: logger}
+	s.current.Store(k)
+	return s
+}
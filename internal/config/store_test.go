@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/knadh/koanf"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestStoreUpdate(t *testing.T) {
+
+	core, logs := observer.New(zap.WarnLevel)
+	s := NewStore(newKoanf(map[string]any{"log.level": "info", "server.port": 8080, "cache.order.soft_ttl": "1m"}), zap.New(core))
+
+	var levels, caches int
+	s.Subscribe(func(k *koanf.Koanf) { levels++ }, "log.level")
+	s.Subscribe(func(k *koanf.Koanf) { caches++ }, "cache.order")
+
+	changed := s.Update(newKoanf(map[string]any{"log.level": "warn", "server.port": 9000, "cache.order.soft_ttl": "1m"}))
+	if len(changed) != 2 || changed[0] != "log.level" || changed[1] != "server.port" {
+		t.Error("变化的配置项不正确", changed)
+	}
+	if levels != 1 || caches != 0 {
+		t.Error("只应通知相关订阅者", levels, caches)
+	}
+	if s.Koanf().String("log.level") != "warn" {
+		t.Error("配置未替换")
+	}
+
+	warns := logs.FilterField(zap.String("key", "server.port")).Len()
+	if warns != 1 || logs.Len() != 1 {
+		t.Error("不支持运行时修改的配置项应记录警告", logs.All())
+	}
+
+	if changed := s.Update(newKoanf(map[string]any{"log.level": "warn", "server.port": 9000, "cache.order.soft_ttl": "1m"})); changed != nil || levels != 1 {
+		t.Error("配置未变化时不应通知", changed)
+	}
+}
@@ -0,0 +1,69 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	b := NewCircuitBreaker(3, 20*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatal("未达到失败阈值时被熔断", i)
+		}
+		b.Failure()
+	}
+
+	if b.Allow() || b.State() != "open" {
+		t.Fatal("达到失败阈值后未熔断", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("熔断时间结束后未放行探测请求")
+	}
+	if b.Allow() {
+		t.Fatal("半开状态放行了多个请求")
+	}
+
+	b.Failure()
+	if b.State() != "open" {
+		t.Fatal("探测失败后未重新熔断", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	b.Allow()
+	b.Success()
+
+	if b.State() != "closed" || !b.Allow() {
+		t.Fatal("探测成功后未恢复", b.State())
+	}
+}
+
+func TestIsBadRequest(t *testing.T) {
+
+	// 查询条件错误不计入熔断，elastic不可用才计入
+	cases := map[error]bool{
+		ErrInvalidCursor: true,
+		fmt.Errorf("解析游标: %w", ErrInvalidCursor): true,
+		&EsError{StatusCode: 400}:                true,
+		&EsError{StatusCode: 404}:                true,
+		&EsError{StatusCode: 429}:                false,
+		&EsError{StatusCode: 500}:                false,
+		&EsError{StatusCode: 503}:                false,
+		context.DeadlineExceeded:                 false,
+		errors.New("connection refused"):         false,
+	}
+
+	for err, want := range cases {
+		if got := IsBadRequest(err); got != want {
+			t.Errorf("错误分类不一致 %v: %v", err, got)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package dao
 
 import (
-	"fmt"
 	"os"
-	"strings"
+
+	"goweb/internal/config"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/estransport"
-	"github.com/knadh/koanf"
 	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -16,22 +15,21 @@ import (
 	"moul.io/zapgorm2"
 )
 
-func NewElasticClient(k *koanf.Koanf) (*elasticsearch.Client, error) {
+func NewElasticClient(conf config.EsConfig) (*elasticsearch.Client, error) {
 
 	cfg := elasticsearch.Config{
-		Addresses: strings.Split(k.String("db.es.address"), ","),
-		Username:  k.String("db.es.user"),
-		Password:  k.String("db.es.password"),
+		Addresses: conf.Address,
+		Username:  conf.User,
+		Password:  conf.Password.Value(),
 		Logger:    &estransport.ColorLogger{Output: os.Stdout},
 	}
 	return elasticsearch.NewClient(cfg)
 }
 
-func NewTidbClient(k *koanf.Koanf, lg *zap.Logger) (*gorm.DB, error) {
+func NewTidbClient(conf config.TidbConfig, lg *zap.Logger) (*gorm.DB, error) {
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4", k.String("db.tidb.user"), k.String("db.tidb.password"),
-		k.String("db.tidb.host"), k.Int("db.tidb.port"), k.String("db.tidb.db"))
-	gormLg := zapgorm2.New(lg)
+	dsn := conf.DSN()
+	gormLg := zapgorm2.New(lg.Named("gorm"))
 	gormLg.SetAsDefault()
 	gormLg.LogLevel = logger.Info
 
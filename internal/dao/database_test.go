@@ -4,39 +4,44 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
+	"goweb/internal/config"
+	"goweb/internal/di"
+
 	"go.uber.org/zap"
 )
 
-func prepare() (*koanf.Koanf, *zap.Logger, error) {
+func prepare() (config.EsConfig, *zap.Logger, error) {
 
-	var k = koanf.New(".")
-	if err := k.Load(file.Provider("../../config/config.yaml"), yaml.Parser()); err != nil {
-		fmt.Printf("加载配置失败 %v", err)
-		return nil, nil, err
+	var conf config.EsConfig
+	k, err := di.InitConf(di.ConfOptions{})
+	if err != nil {
+		return conf, nil, err
+	}
+
+	conf, err = config.NewEsConfig(k)
+	if err != nil {
+		return conf, nil, err
 	}
 
 	logger, err := zap.NewDevelopment()
 
 	if err != nil {
 		fmt.Printf("创建日志失败 %v", err)
-		return nil, nil, err
+		return conf, nil, err
 	}
 
-	return k, logger, nil
+	return conf, logger, nil
 }
 
 func TestConnect(t *testing.T) {
 
-	k, _, err := prepare()
+	conf, _, err := prepare()
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	c, err := NewElasticClient(k)
+	c, err := NewElasticClient(conf)
 
 	if err != nil {
 		t.Error(err)
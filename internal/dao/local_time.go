@@ -12,6 +12,12 @@ type LocalTime struct {
 	time.Time
 }
 
+// NewLocalTime 截断到秒并去掉时区，与从数据库读出的时间保持一致
+func NewLocalTime(t time.Time) *LocalTime {
+	lt, _ := time.Parse(timeFormat, t.Format(timeFormat))
+	return &LocalTime{Time: lt}
+}
+
 // Scan implements sql.Scanner interface
 func (t *LocalTime) Scan(value interface{}) error {
 	timestr, ok := value.([]byte)
@@ -0,0 +1,328 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pitKeepAlive 两次翻页之间point in time的保留时间，放弃翻页后尽快释放；
+// 过期后按search_after位置重新打开，翻页较慢的用户不受影响
+const pitKeepAlive = "1m"
+
+// CursorStart 首次游标分页请求使用的游标
+const CursorStart = "*"
+
+var (
+	ErrInvalidCursor     = errors.New("游标无效")
+	ErrSearchUnavailable = errors.New("elastic不可用")
+)
+
+// OrderCursor 游标分页位置，Digest绑定查询条件，防止游标用于其他条件；
+// Key为默认排序下的键集位置，elastic不可用时由数据库继续分页，Total为第一页查询的总数，数据库翻页时不再重复统计
+type OrderCursor struct {
+	Pit         string            `json:"p,omitempty"`
+	SearchAfter []json.RawMessage `json:"a,omitempty"`
+	Key         *OrderKey         `json:"k,omitempty"`
+	Total       int64             `json:"n,omitempty"`
+	Digest      string            `json:"d"`
+}
+
+// OrderKey 按创建时间、订单号倒序时最后一条订单的位置
+type OrderKey struct {
+	CreateTime string `json:"c"`
+	TradeNo    string `json:"t"`
+}
+
+func orderKey(order *TradeOrder) *OrderKey {
+	if order.CreateTime == nil {
+		return nil
+	}
+	return &OrderKey{CreateTime: order.CreateTime.Format(timeFormat), TradeNo: order.TradeNo}
+}
+
+// searchAfter 与cursorSort对应的elastic排序值，日期为毫秒时间戳
+func (k *OrderKey) searchAfter() ([]any, error) {
+	t, err := time.Parse(timeFormat, k.CreateTime)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return []any{t.UnixMilli(), k.TradeNo}, nil
+}
+
+func (c *OrderCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// AfterKey 游标在结果中的位置，不含point in time，可作为缓存key
+func (c *OrderCursor) AfterKey() string {
+	if len(c.SearchAfter) == 0 && c.Key != nil {
+		return c.Key.CreateTime + "," + c.Key.TradeNo
+	}
+
+	var b strings.Builder
+	for i, v := range c.SearchAfter {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(v)
+	}
+	return b.String()
+}
+
+// ParseOrderCursor 解析游标，CursorStart表示从第一页开始
+func ParseOrderCursor(cursor string, q *OrderQuery) (*OrderCursor, error) {
+	digest := q.Digest()
+	if cursor == CursorStart {
+		return &OrderCursor{Digest: digest}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c OrderCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Digest != digest {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (dao *OrderDao) openPit(ctx context.Context) (string, error) {
+	res, err := dao.es.OpenPointInTime([]string{orderIndex}, pitKeepAlive, dao.es.OpenPointInTime.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", &EsError{StatusCode: res.StatusCode, Message: res.String()}
+	}
+
+	var ret struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return "", err
+	}
+	return ret.Id, nil
+}
+
+func (dao *OrderDao) closePit(pit string) {
+	body, _ := json.Marshal(map[string]string{"id": pit})
+	res, err := dao.es.ClosePointInTime(dao.es.ClosePointInTime.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		dao.logger.Warn("关闭point in time失败", zap.Error(err))
+		return
+	}
+	res.Body.Close()
+}
+
+// searchAfter 在point in time上按search_after查询下一页，point in time过期时重新打开；
+// 数据库返回的游标只有键集位置，转换为对应的排序值
+func (dao *OrderDao) searchAfter(ctx context.Context, size int, q *OrderQuery, sort []any, cursor *OrderCursor, trackTotal bool) (*EsResponse[*TradeOrder], error) {
+
+	query := map[string]interface{}{
+		"size":             size,
+		"query":            q.esQuery(),
+		"sort":             sort,
+		"track_total_hits": trackTotal,
+	}
+	if len(cursor.SearchAfter) > 0 {
+		query["search_after"] = cursor.SearchAfter
+	} else if cursor.Key != nil {
+		after, err := cursor.Key.searchAfter()
+		if err != nil {
+			return nil, err
+		}
+		query["search_after"] = after
+	}
+
+	for retry := 0; ; retry++ {
+		if cursor.Pit == "" {
+			pit, err := dao.openPit(ctx)
+			if err != nil {
+				return nil, err
+			}
+			cursor.Pit = pit
+		}
+
+		query["pit"] = map[string]interface{}{"id": cursor.Pit, "keep_alive": pitKeepAlive}
+
+		ret, err := dao.search(ctx, query)
+		if retry == 0 && pitMissing(err) {
+			// point in time已过期，search_after的排序值仍然有效，重新打开后继续
+			cursor.Pit = ""
+			continue
+		}
+		return ret, err
+	}
+}
+
+// pitMissing point in time已过期或被释放，索引不存在等其他404不重新打开
+func pitMissing(err error) bool {
+	var esErr *EsError
+	return errors.As(err, &esErr) && esErr.StatusCode == http.StatusNotFound &&
+		strings.Contains(esErr.Message, "search_context_missing_exception")
+}
+
+// GetOrderAfter 游标分页查询，返回的游标为空表示没有更多数据；
+// elastic异常或熔断时，未指定排序的查询按键集降级查询数据库
+func (dao *OrderDao) GetOrderAfter(size int, q *OrderQuery, cursor *OrderCursor) (int64, []*TradeOrder, *OrderCursor, OrderSource, error) {
+
+	if dao.breaker.Allow() {
+		ctx, cancel := context.WithTimeout(context.Background(), dao.timeout())
+		total, orders, next, err := dao.searchOrderAfter(ctx, size, q, cursor)
+		cancel()
+
+		if err == nil || IsBadRequest(err) {
+			dao.breaker.Success()
+			return total, orders, next, SourceElastic, err
+		}
+
+		dao.breaker.Failure()
+		dao.logger.Warn("游标查询elastic失败", zap.String("breaker", dao.breaker.State()), zap.Error(err))
+	}
+
+	// 指定排序的游标只有elastic的排序值，无法由数据库继续
+	if !q.keyset() || (cursor.Key == nil && len(cursor.SearchAfter) > 0) {
+		return 0, nil, nil, SourceTidb, ErrSearchUnavailable
+	}
+
+	total, orders, next, err := dao.getOrderAfter(size, q, cursor)
+	if err != nil {
+		return 0, nil, nil, SourceTidb, err
+	}
+	return total, orders, next, SourceTidb, nil
+}
+
+func (dao *OrderDao) searchOrderAfter(ctx context.Context, size int, q *OrderQuery, cursor *OrderCursor) (int64, []*TradeOrder, *OrderCursor, error) {
+
+	ret, err := dao.searchAfter(ctx, size, q, q.cursorSort(), cursor, true)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	orders := make([]*TradeOrder, len(ret.Hits.Index))
+	for i, ih := range ret.Hits.Index {
+		orders[i] = ih.Source
+	}
+
+	pit := ret.PitId
+	if pit == "" {
+		pit = cursor.Pit
+	}
+
+	if len(orders) < size || len(orders) == 0 {
+		dao.closePit(pit)
+		return ret.Hits.Total.Value, orders, nil, nil
+	}
+
+	next := &OrderCursor{
+		Pit:         pit,
+		SearchAfter: ret.Hits.Index[len(orders)-1].Sort,
+		Total:       ret.Hits.Total.Value,
+		Digest:      cursor.Digest,
+	}
+	if q.keyset() {
+		next.Key = orderKey(orders[len(orders)-1])
+	}
+	return ret.Hits.Total.Value, orders, next, nil
+}
+
+// getOrderAfter 按创建时间、订单号倒序的键集分页，与cursorSort顺序一致
+func (dao *OrderDao) getOrderAfter(size int, q *OrderQuery, cursor *OrderCursor) (int64, []*TradeOrder, *OrderCursor, error) {
+
+	var orders []*TradeOrder
+
+	// 只在第一页统计总数，之后由游标带回
+	count := cursor.Total
+	if count == 0 {
+		if err := q.where(dao.orderQuery().Where("trade_order.is_deleted = 0")).Count(&count).Error; err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	db := q.where(dao.orderQuery().Where("trade_order.is_deleted = 0"))
+	if k := cursor.Key; k != nil {
+		db = db.Where("(trade_order.create_time < ? OR (trade_order.create_time = ? AND trade_order.trade_no < ?))",
+			k.CreateTime, k.CreateTime, k.TradeNo)
+	}
+
+	ret := db.Order("trade_order.create_time desc, trade_order.trade_no desc").Limit(size).Find(&orders)
+	if ret.Error != nil {
+		return 0, nil, nil, ret.Error
+	}
+
+	if len(orders) < size || len(orders) == 0 {
+		return count, orders, nil, nil
+	}
+
+	// 保留point in time，elastic恢复后可继续使用
+	next := &OrderCursor{Pit: cursor.Pit, Key: orderKey(orders[len(orders)-1]), Total: count, Digest: cursor.Digest}
+	if next.Key == nil {
+		return count, orders, nil, nil
+	}
+	return count, orders, next, nil
+}
+
+// ScanOrders 从cursor位置开始按批遍历全部匹配订单，fn收到每批订单及该批之后的位置
+func (dao *OrderDao) ScanOrders(ctx context.Context, q *OrderQuery, cursor *OrderCursor, batch int, fn func(orders []*TradeOrder, after *OrderCursor) error) error {
+
+	if !dao.breaker.Allow() {
+		return ErrSearchUnavailable
+	}
+
+	if cursor == nil {
+		cursor = &OrderCursor{Digest: q.Digest()}
+	}
+	defer func() {
+		if cursor.Pit != "" {
+			dao.closePit(cursor.Pit)
+		}
+	}()
+
+	for {
+		ret, err := dao.searchAfter(ctx, batch, q, q.esSort(), cursor, false)
+		if err != nil && !IsBadRequest(err) {
+			dao.breaker.Failure()
+			return err
+		}
+		dao.breaker.Success()
+		if err != nil {
+			return err
+		}
+
+		hits := ret.Hits.Index
+		if len(hits) == 0 {
+			return nil
+		}
+
+		if ret.PitId != "" {
+			cursor.Pit = ret.PitId
+		}
+		cursor.SearchAfter = hits[len(hits)-1].Sort
+
+		orders := make([]*TradeOrder, len(hits))
+		for i, ih := range hits {
+			orders[i] = ih.Source
+		}
+
+		if err := fn(orders, &OrderCursor{SearchAfter: cursor.SearchAfter, Digest: cursor.Digest}); err != nil {
+			return err
+		}
+
+		if len(hits) < batch {
+			return nil
+		}
+	}
+}
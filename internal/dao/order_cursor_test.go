@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOrderCursor(t *testing.T) {
+
+	q := &OrderQuery{UserId: 1}
+
+	start, err := ParseOrderCursor(CursorStart, q)
+	if err != nil || start.Pit != "" || len(start.SearchAfter) != 0 {
+		t.Fatal("起始游标解析失败", start, err)
+	}
+
+	c := &OrderCursor{
+		Pit:         "pit",
+		SearchAfter: []json.RawMessage{json.RawMessage(`1654041600000`), json.RawMessage(`"1536972017172901888"`)},
+		Digest:      q.Digest(),
+	}
+
+	got, err := ParseOrderCursor(c.Encode(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Pit != c.Pit || got.AfterKey() != `1654041600000,"1536972017172901888"` {
+		t.Error("游标解析结果不一致", got)
+	}
+
+	if _, err := ParseOrderCursor(c.Encode(), &OrderQuery{UserId: 2}); err != ErrInvalidCursor {
+		t.Error("游标用于其他查询条件未报错")
+	}
+	if _, err := ParseOrderCursor("not-a-cursor", q); err != ErrInvalidCursor {
+		t.Error("非法游标未报错")
+	}
+}
+
+func TestOrderCursorKey(t *testing.T) {
+
+	q := &OrderQuery{UserId: 1}
+	if !q.keyset() || len(q.cursorSort()) != 2 {
+		t.Error("未指定排序时应按创建时间、订单号键集分页", q.cursorSort())
+	}
+	if sorted := (&OrderQuery{Sort: []OrderSort{{Field: "TotalAmount"}}}); sorted.keyset() {
+		t.Error("指定排序时不能按键集分页")
+	}
+
+	// 数据库返回的游标只有键集位置，elastic按对应的排序值继续
+	order := &TradeOrder{TradeNo: "1536972017172901888", CreateTime: NewLocalTime(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))}
+	c := &OrderCursor{Key: orderKey(order), Digest: q.Digest()}
+
+	got, err := ParseOrderCursor(c.Encode(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := got.Key.searchAfter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after[0] != int64(1654041600000) || after[1] != order.TradeNo {
+		t.Error("键集位置转换不一致", after)
+	}
+	if got.AfterKey() != "2022-06-01 00:00:00,1536972017172901888" {
+		t.Error("键集游标的缓存key不一致", got.AfterKey())
+	}
+}
+
+func TestPitMissing(t *testing.T) {
+
+	expired := &EsError{StatusCode: 404, Message: `[404 Not Found] {"error":{"root_cause":[{"type":"search_context_missing_exception","reason":"No search context found for id [1]"}]},"status":404}`}
+	if !pitMissing(expired) {
+		t.Error("point in time过期时应重新打开")
+	}
+
+	// 索引不存在时重新打开point in time也会失败
+	missingIndex := &EsError{StatusCode: 404, Message: `[404 Not Found] {"error":{"root_cause":[{"type":"index_not_found_exception","reason":"no such index [trade_order]"}]},"status":404}`}
+	if pitMissing(missingIndex) || pitMissing(&EsError{StatusCode: 500}) || pitMissing(nil) {
+		t.Error("其他错误不应重新打开point in time")
+	}
+}
@@ -4,15 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"goweb/internal/config"
 
 	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/knadh/koanf"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type EsResponse[T any] struct {
-	Hits *EsHit[T] `json:"hits"`
+	PitId string    `json:"pit_id"`
+	Hits  *EsHit[T] `json:"hits"`
 }
 
 type EsHit[T any] struct {
@@ -20,22 +31,42 @@ type EsHit[T any] struct {
 	Index []*IndexHit[T] `json:"hits"`
 }
 
+type EsError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EsError) Error() string {
+	return fmt.Sprintf("查询elastic失败: %s", e.Message)
+}
+
+// IsBadRequest 查询条件错误：游标无效或elastic返回429以外的4xx，elastic本身可用，
+// 不计入熔断也不降级查询数据库；网络错误、超时及5xx、429才是elastic不可用
+func IsBadRequest(err error) bool {
+	if errors.Is(err, ErrInvalidCursor) {
+		return true
+	}
+	var esErr *EsError
+	return errors.As(err, &esErr) && esErr.StatusCode >= 400 && esErr.StatusCode < 500 && esErr.StatusCode != http.StatusTooManyRequests
+}
+
 type TotalHit struct {
 	Value    int64  `json:"value"`
 	Relation string `json:"relation"`
 }
 
 type IndexHit[T any] struct {
-	Index  string `json:"_index"`
-	Id     string `json:"_id"`
-	Source T      `json:"_source"`
+	Index  string            `json:"_index"`
+	Id     string            `json:"_id"`
+	Source T                 `json:"_source"`
+	Sort   []json.RawMessage `json:"sort"`
 }
 
 type TradeOrder struct {
 	TradeNo        string `gorm:"primaryKey"`
 	UserId         string
-	UserCode       string
-	Nickname       string
+	UserCode       string `gorm:"->"`
+	Nickname       string `gorm:"->"`
 	Subject        string
 	TotalAmount    float64 `json:",string"`
 	DiscountAmount float64 `json:",string"`
@@ -46,60 +77,127 @@ type TradeOrder struct {
 	CreateUser     string
 	UpdateTime     *LocalTime
 	UpdateUser     string
-	Deleted        int
+	Deleted        int `gorm:"column:is_deleted"`
 }
 
+const orderIndex = "trade_order"
+
+var (
+	ErrOrderNotFound = errors.New("订单不存在")
+	ErrOrderConflict = errors.New("订单已被修改")
+	ErrOrderAmount   = errors.New("优惠金额或实付金额超过订单总额")
+)
+
+// OrderSource 查询结果的数据来源
+type OrderSource string
+
+const (
+	SourceElastic OrderSource = "elastic"
+	SourceTidb    OrderSource = "tidb"
+)
+
 type OrderDao struct {
-	es     *elasticsearch.Client
-	db     *gorm.DB
-	logger *zap.Logger
+	es        *elasticsearch.Client
+	db        *gorm.DB
+	idGen     *TradeNoGenerator
+	breaker   *CircuitBreaker
+	esTimeout atomic.Int64
+	logger    *zap.Logger
 }
 
-func (dao *OrderDao) GetOrder(page, size int, tradeNo, userId uint64) (int64, []*TradeOrder, error) {
-	offset := page * size
-	matchMap := make(map[string]interface{})
-	if tradeNo != 0 {
-		matchMap["trade_no"] = tradeNo
-	}
+// timeout elastic请求超时时间，支持运行时修改
+func (dao *OrderDao) timeout() time.Duration {
+	return time.Duration(dao.esTimeout.Load())
+}
 
-	if userId != 0 {
-		matchMap["user_id"] = userId
+func (dao *OrderDao) reload(k *koanf.Koanf) {
+	conf, err := config.NewEsConfig(k)
+	if err != nil {
+		dao.logger.Warn("elastic配置无效，保留当前超时时间", zap.Error(err))
+		return
 	}
+	dao.esTimeout.Store(int64(conf.Timeout))
+}
 
-	var buf bytes.Buffer
-	query := map[string]interface{}{
-		"from": offset,
-		"size": size,
-	}
-	if len(matchMap) > 0 {
-		query["query"] = map[string]interface{}{
-			"match": matchMap,
+// GetOrder 优先查询elastic，elastic不可用、超时或熔断时降级查询数据库，查询条件错误直接返回
+func (dao *OrderDao) GetOrder(page, size int, q *OrderQuery) (int64, []*TradeOrder, OrderSource, error) {
+
+	if dao.breaker.Allow() {
+		ctx, cancel := context.WithTimeout(context.Background(), dao.timeout())
+		total, orders, err := dao.searchOrder(ctx, page, size, q)
+		cancel()
+
+		if err == nil || IsBadRequest(err) {
+			dao.breaker.Success()
+			return total, orders, SourceElastic, err
 		}
+
+		dao.breaker.Failure()
+		dao.logger.Warn("查询elastic失败，降级查询数据库", zap.String("breaker", dao.breaker.State()), zap.Error(err))
+	}
+
+	total, orders, err := dao.getOrder(page, size, q)
+	if err != nil {
+		return 0, nil, SourceTidb, err
 	}
 
+	return total, orders, SourceTidb, nil
+}
+
+// searchRaw 执行查询并反序列化到out，使用point in time查询时不能指定索引
+func (dao *OrderDao) searchRaw(ctx context.Context, query map[string]interface{}, out any, index ...string) error {
+
+	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(query); err != nil {
 		dao.logger.Error("序列化请求条件失败", zap.Error(err))
-		return 0, nil, err
+		return err
 	}
 
 	res, err := dao.es.Search(
-		dao.es.Search.WithContext(context.Background()),
-		dao.es.Search.WithIndex("trade_order"),
+		dao.es.Search.WithContext(ctx),
+		dao.es.Search.WithIndex(index...),
 		dao.es.Search.WithBody(&buf),
-		dao.es.Search.WithTrackTotalHits(true),
 		dao.es.Search.WithPretty(),
 	)
 
 	if err != nil {
 		dao.logger.Error("查询elastic失败", zap.Error(err))
-		return 0, nil, err
+		return err
 	}
+	defer res.Body.Close()
 
-	var ret EsResponse[*TradeOrder]
-	err = json.NewDecoder(res.Body).Decode(&ret)
+	if res.IsError() {
+		return &EsError{StatusCode: res.StatusCode, Message: res.String()}
+	}
+
+	err = json.NewDecoder(res.Body).Decode(out)
 
 	if err != nil {
 		dao.logger.Error("反序列化查询结果失败", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (dao *OrderDao) search(ctx context.Context, query map[string]interface{}, index ...string) (*EsResponse[*TradeOrder], error) {
+	var ret EsResponse[*TradeOrder]
+	if err := dao.searchRaw(ctx, query, &ret, index...); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *OrderDao) searchOrder(ctx context.Context, page, size int, q *OrderQuery) (int64, []*TradeOrder, error) {
+
+	ret, err := dao.search(ctx, map[string]interface{}{
+		"from":             page * size,
+		"size":             size,
+		"query":            q.esQuery(),
+		"sort":             q.esSort(),
+		"track_total_hits": true,
+	}, orderIndex)
+	if err != nil {
 		return 0, nil, err
 	}
 
@@ -112,25 +210,37 @@ func (dao *OrderDao) GetOrder(page, size int, tradeNo, userId uint64) (int64, []
 
 }
 
-func (dao *OrderDao) getOrder(page, size int, tradeNo, userId uint64) (int64, []*TradeOrder, error) {
-
-	var order []*TradeOrder
-	var count int64
+func (dao *OrderDao) orderQuery() *gorm.DB {
+	return dao.db.
+		Model(&TradeOrder{}).
+		Select("trade_no, trade_order.user_id, tus.user_code, tus.nickname, subject, total_amount, discount_amount, payment_amount, expire_time, trade_status, create_time, create_user, update_time, update_user, trade_order.is_deleted").
+		Joins("LEFT JOIN trade_user_sync tus on tus.user_id = trade_order.user_id")
+}
 
-	var db = dao.db.
-		Model(&order).
-		Select("trade_no, trade_order.user_id, tus.user_code, tus.nickname, subject, total_amount, discount_amount, payment_amount, expire_time, trade_status, create_time, create_user, update_time, update_user, is_deleted").
-		Joins("LEFT JOIN trade_user_sync tus on tus.user_id = trade_order.user_id").
-		Where("trade_order.is_deleted = 0")
+// findOrder 按订单号查询，包含已删除订单
+func (dao *OrderDao) findOrder(tradeNo string) (*TradeOrder, error) {
 
-	if tradeNo > 0 {
-		db = db.Where("trade_order.trade_no = ?", tradeNo)
+	var order []*TradeOrder
+	ret := dao.orderQuery().Where("trade_order.trade_no = ?", tradeNo).Limit(1).Find(&order)
+	if ret.Error != nil {
+		return nil, ret.Error
 	}
-	if userId > 0 {
-		db = db.Where("trade_order.user_id = ?", userId)
+
+	if len(order) == 0 {
+		return nil, ErrOrderNotFound
 	}
 
-	ret := db.Order("trade_order.trade_no desc").
+	return order[0], nil
+}
+
+func (dao *OrderDao) getOrder(page, size int, q *OrderQuery) (int64, []*TradeOrder, error) {
+
+	var order []*TradeOrder
+	var count int64
+
+	var db = q.where(dao.orderQuery().Where("trade_order.is_deleted = 0"))
+
+	ret := q.order(db).
 		Offset(page * size).
 		Limit(size).
 		Find(&order).
@@ -147,10 +257,251 @@ func (dao *OrderDao) getOrder(page, size int, tradeNo, userId uint64) (int64, []
 	return count, order, nil
 }
 
-func NewOrderDao(es *elasticsearch.Client, db *gorm.DB, logger *zap.Logger) *OrderDao {
-	return &OrderDao{es: es, db: db, logger: logger}
+func (dao *OrderDao) AddOrder(order *TradeOrder) error {
+
+	now := NewLocalTime(time.Now())
+	order.TradeNo = strconv.FormatUint(dao.idGen.Next(), 10)
+	order.CreateTime = now
+	order.UpdateTime = now
+	order.UpdateUser = order.CreateUser
+	order.Deleted = 0
+
+	if err := dao.db.Create(order).Error; err != nil {
+		dao.logger.Error("写入订单失败", zap.String("tradeNo", order.TradeNo), zap.Error(err))
+		return err
+	}
+
+	// 数据库已写入成功，索引失败不回滚，由同步任务补偿
+	if err := dao.indexOrder(order); err != nil {
+		dao.logger.Warn("写入elastic失败", zap.String("tradeNo", order.TradeNo), zap.Error(err))
+	}
+
+	return nil
+}
+
+// AddOrders 在一个事务中批量写入订单，再批量写入elastic
+func (dao *OrderDao) AddOrders(orders []*TradeOrder) error {
+
+	now := NewLocalTime(time.Now())
+	for _, order := range orders {
+		order.TradeNo = strconv.FormatUint(dao.idGen.Next(), 10)
+		order.CreateTime = now
+		order.UpdateTime = now
+		order.UpdateUser = order.CreateUser
+		order.Deleted = 0
+	}
+
+	err := dao.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(orders).Error
+	})
+	if err != nil {
+		dao.logger.Error("批量写入订单失败", zap.Int("count", len(orders)), zap.Error(err))
+		return err
+	}
+
+	// 与AddOrder相同，索引失败不回滚，由同步任务补偿
+	ctx, cancel := context.WithTimeout(context.Background(), dao.timeout()*time.Duration(1+len(orders)/500))
+	defer cancel()
+
+	failed, err := dao.bulkIndex(ctx, orders)
+	if err != nil || len(failed) > 0 {
+		dao.logger.Warn("批量写入elastic失败", zap.Int("count", len(orders)), zap.Int("failed", len(failed)), zap.Error(err))
+	}
+
+	return nil
+}
+
+// nextUpdateTime 保证更新时间严格递增，同一秒内的连续修改也能被识别
+func nextUpdateTime(updateTime *LocalTime) *LocalTime {
+	next := NewLocalTime(time.Now())
+	if !next.After(updateTime.Time) {
+		next = &LocalTime{Time: updateTime.Add(time.Second)}
+	}
+	return next
+}
+
+func sameUpdateTime(current, updateTime *LocalTime) bool {
+	return current != nil && current.Format(timeFormat) == updateTime.Format(timeFormat)
+}
+
+// amountValue 更新后的金额，未修改时取当前记录的值
+func amountValue(fields map[string]any, column string, current float64) float64 {
+	if v, ok := fields[column].(float64); ok {
+		return v
+	}
+	return current
+}
+
+// checkAmount 修改金额时校验优惠金额及实付金额不超过订单总额
+func checkAmount(order *TradeOrder, fields map[string]any) error {
+	_, total := fields["total_amount"]
+	_, discount := fields["discount_amount"]
+	_, payment := fields["payment_amount"]
+	if !total && !discount && !payment {
+		return nil
+	}
+
+	totalAmount := amountValue(fields, "total_amount", order.TotalAmount)
+	if amountValue(fields, "discount_amount", order.DiscountAmount) > totalAmount ||
+		amountValue(fields, "payment_amount", order.PaymentAmount) > totalAmount {
+		return ErrOrderAmount
+	}
+	return nil
+}
+
+// lockOrder 在事务中锁定订单记录
+func lockOrder(tx *gorm.DB, tradeNo string) (*TradeOrder, error) {
+
+	var order []*TradeOrder
+	ret := tx.Model(&TradeOrder{}).
+		Select("trade_no, total_amount, discount_amount, payment_amount, update_time, is_deleted").
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("trade_no = ?", tradeNo).Limit(1).Find(&order)
+	if ret.Error != nil {
+		return nil, ret.Error
+	}
+
+	if len(order) == 0 || order[0].Deleted != 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	return order[0], nil
+}
+
+// UpdateOrder 以UpdateTime作为乐观锁更新订单，记录已被修改时返回ErrOrderConflict及当前记录；
+// 在事务中锁定当前记录，按更新后的金额校验优惠金额及实付金额不超过订单总额，不满足时返回ErrOrderAmount
+func (dao *OrderDao) UpdateOrder(tradeNo string, updateTime *LocalTime, updateUser string, fields map[string]any) (*TradeOrder, error) {
+
+	updates := make(map[string]any, len(fields)+2)
+	for k, v := range fields {
+		updates[k] = v
+	}
+	updates["update_time"] = nextUpdateTime(updateTime)
+	updates["update_user"] = updateUser
+
+	err := dao.db.Transaction(func(tx *gorm.DB) error {
+		order, err := lockOrder(tx, tradeNo)
+		if err != nil {
+			return err
+		}
+		if !sameUpdateTime(order.UpdateTime, updateTime) {
+			return ErrOrderConflict
+		}
+		if err := checkAmount(order, fields); err != nil {
+			return err
+		}
+		return tx.Model(&TradeOrder{}).Where("trade_no = ?", tradeNo).Updates(updates).Error
+	})
+
+	if err != nil && !errors.Is(err, ErrOrderConflict) && !errors.Is(err, ErrOrderAmount) {
+		if !errors.Is(err, ErrOrderNotFound) {
+			dao.logger.Error("更新订单失败", zap.String("tradeNo", tradeNo), zap.Error(err))
+		}
+		return nil, err
+	}
+
+	// 冲突或金额校验失败时同样返回当前记录
+	current, findErr := dao.findOrder(tradeNo)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if err != nil {
+		return current, err
+	}
+
+	if err := dao.indexOrder(current); err != nil {
+		dao.logger.Warn("写入elastic失败", zap.String("tradeNo", tradeNo), zap.Error(err))
+	}
+
+	return current, nil
+}
+
+// DeleteOrder 以UpdateTime作为乐观锁软删除订单，同步更新elastic中的删除标记
+func (dao *OrderDao) DeleteOrder(tradeNo string, updateTime *LocalTime, updateUser string) (*TradeOrder, error) {
+	return dao.setDeleted(tradeNo, updateTime, updateUser, 1)
+}
+
+// RestoreOrder 以UpdateTime作为乐观锁恢复已软删除的订单
+func (dao *OrderDao) RestoreOrder(tradeNo string, updateTime *LocalTime, updateUser string) (*TradeOrder, error) {
+	return dao.setDeleted(tradeNo, updateTime, updateUser, 0)
+}
+
+func (dao *OrderDao) setDeleted(tradeNo string, updateTime *LocalTime, updateUser string, deleted int) (*TradeOrder, error) {
+
+	ret := dao.db.Model(&TradeOrder{}).
+		Where("trade_no = ? AND update_time = ? AND is_deleted = ?", tradeNo, updateTime, 1-deleted).
+		Updates(map[string]any{
+			"is_deleted":  deleted,
+			"update_time": nextUpdateTime(updateTime),
+			"update_user": updateUser,
+		})
+
+	if ret.Error != nil {
+		dao.logger.Error("更新订单删除标记失败", zap.String("tradeNo", tradeNo), zap.Int("deleted", deleted), zap.Error(ret.Error))
+		return nil, ret.Error
+	}
+
+	current, err := dao.findOrder(tradeNo)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret.RowsAffected == 0 {
+		if current.Deleted != 1-deleted {
+			return nil, ErrOrderNotFound
+		}
+		return current, ErrOrderConflict
+	}
+
+	if err := dao.indexOrder(current); err != nil {
+		dao.logger.Warn("写入elastic失败", zap.String("tradeNo", tradeNo), zap.Error(err))
+	}
+
+	return current, nil
+}
+
+func (dao *OrderDao) indexOrder(order *TradeOrder) error {
+
+	body, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	res, err := dao.es.Index(orderIndex, bytes.NewReader(body),
+		dao.es.Index.WithContext(context.Background()),
+		dao.es.Index.WithDocumentID(order.TradeNo),
+		dao.es.Index.WithRefresh("wait_for"),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("写入elastic失败: %s", res.String())
+	}
+
+	return nil
+}
+
+func NewOrderDao(conf config.EsConfig, es *elasticsearch.Client, db *gorm.DB, idGen *TradeNoGenerator, logger *zap.Logger) *OrderDao {
+
+	dao := &OrderDao{
+		es:      es,
+		db:      db,
+		idGen:   idGen,
+		breaker: NewCircuitBreaker(conf.Breaker.Failures, conf.Breaker.Open),
+		logger:  logger.Named("dao"),
+	}
+	dao.esTimeout.Store(int64(conf.Timeout))
+	return dao
 }
 
 func ProvideOrderDao() fx.Option {
-	return fx.Provide(NewElasticClient, NewTidbClient, NewOrderDao)
+	return fx.Options(
+		fx.Provide(NewElasticClient, NewTidbClient, NewTradeNoGenerator, NewOrderDao),
+		fx.Invoke(func(store *config.Store, dao *OrderDao) {
+			store.Subscribe(dao.reload, "db.es.timeout")
+		}),
+	)
 }
@@ -4,42 +4,62 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"strings"
 	"testing"
 
+	"goweb/internal/config"
+	"goweb/internal/di"
+
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esutil"
-	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
-func Prepare() (*elasticsearch.Client, *gorm.DB, *zap.Logger) {
+func Prepare() (config.EsConfig, *elasticsearch.Client, *gorm.DB, *TradeNoGenerator, *zap.Logger) {
 
 	logger, _ := zap.NewDevelopment()
 
-	var k = koanf.New(".")
-	if err := k.Load(file.Provider("../../config/config.yaml"), yaml.Parser()); err != nil {
-		fmt.Printf("加载配置失败 %v", err)
-		return nil, nil, nil
+	var esConf config.EsConfig
+	k, err := di.InitConf(di.ConfOptions{})
+	if err != nil {
+		return esConf, nil, nil, nil, nil
 	}
 
-	db, err := NewTidbClient(k, logger)
+	tidbConf, err := config.NewTidbConfig(k)
+	if err != nil {
+		logger.Error("加载数据库配置失败", zap.Error(err))
+		return esConf, nil, nil, nil, nil
+	}
+	db, err := NewTidbClient(tidbConf, logger)
 	if err != nil {
 		logger.Error("打开数据库失败", zap.Error(err))
-		return nil, nil, nil
+		return esConf, nil, nil, nil, nil
 	}
 
-	es, err := NewElasticClient(k)
+	esConf, err = config.NewEsConfig(k)
+	if err != nil {
+		logger.Error("加载elastic配置失败", zap.Error(err))
+		return esConf, nil, nil, nil, nil
+	}
+	es, err := NewElasticClient(esConf)
 	if err != nil {
 		logger.Error("打开elastic失败", zap.Error(err))
-		return nil, nil, nil
+		return esConf, nil, nil, nil, nil
 	}
 
-	return es, db, logger
+	serverConf, err := config.NewServerConfig(k)
+	if err != nil {
+		logger.Error("加载服务配置失败", zap.Error(err))
+		return esConf, nil, nil, nil, nil
+	}
+	idGen, err := NewTradeNoGenerator(serverConf)
+	if err != nil {
+		logger.Error("创建订单号生成器失败", zap.Error(err))
+		return esConf, nil, nil, nil, nil
+	}
+
+	return esConf, es, db, idGen, logger
 }
 
 func initOrderIndex(dao *OrderDao) error {
@@ -78,7 +98,7 @@ func exportTidbToElastic(dao *OrderDao) error {
 
 	index := "trade_order"
 
-	_, orders, err := dao.getOrder(0, 100, 0, 0)
+	_, orders, err := dao.getOrder(0, 100, &OrderQuery{})
 
 	if err != nil {
 		return err
@@ -139,17 +159,39 @@ func TestGetOrder(t *testing.T) {
 	// }
 
 	// var tradeNo uint64 = 1536972017172901888
-	// order, err := dao.GetOrder(0, 10, tradeNo, 0)
+	// order, err := dao.GetOrder(0, 10, &OrderQuery{TradeNo: tradeNo})
 	// if err != nil {
 	// 	logger.Error("查询订单失败", zap.Error(err))
 	// }
 
 	// logger.Info("查询结果", zap.Any("订单", order))
 
-	total, order, err := dao.GetOrder(0, 10, 0, 0)
+	total, order, source, err := dao.GetOrder(0, 10, &OrderQuery{})
 	if err != nil {
 		dao.logger.Error("查询订单失败", zap.Error(err))
 	}
 
-	dao.logger.Info("查询结果", zap.Int64("总数", total), zap.Any("订单", len(order)))
+	dao.logger.Info("查询结果", zap.Int64("总数", total), zap.Any("订单", len(order)), zap.String("来源", string(source)))
+}
+
+func TestCheckAmount(t *testing.T) {
+
+	order := &TradeOrder{TotalAmount: 100, DiscountAmount: 10, PaymentAmount: 90}
+
+	cases := []struct {
+		fields map[string]any
+		err    error
+	}{
+		{map[string]any{"subject": "test"}, nil},
+		{map[string]any{"total_amount": 90.0}, nil},
+		{map[string]any{"total_amount": 50.0}, ErrOrderAmount},
+		{map[string]any{"discount_amount": 120.0}, ErrOrderAmount},
+		{map[string]any{"total_amount": 200.0, "payment_amount": 150.0}, nil},
+	}
+
+	for _, c := range cases {
+		if err := checkAmount(order, c.fields); err != c.err {
+			t.Error("金额校验结果不一致", c.fields, err)
+		}
+	}
 }
@@ -0,0 +1,407 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"goweb/internal/config"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// orderIndexVersion 当前订单索引版本，修改映射时新增版本并递增
+const orderIndexVersion = 2
+
+var orderIndexMappings = map[int]string{
+	1: `{
+  "settings": {
+    "number_of_shards": 1
+  },
+  "mappings": {
+    "properties": {
+      "TotalAmount": { "type": "double" },
+      "DiscountAmount": { "type": "double" },
+      "PaymentAmount": { "type": "double" }
+    }
+  }
+}`,
+	2: `{
+  "settings": {
+    "number_of_shards": 1
+  },
+  "mappings": {
+    "properties": {
+      "TradeNo": { "type": "keyword" },
+      "UserId": { "type": "keyword" },
+      "UserCode": { "type": "keyword" },
+      "Nickname": { "type": "keyword" },
+      "Subject": {
+        "type": "text",
+        "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } }
+      },
+      "TotalAmount": { "type": "double" },
+      "DiscountAmount": { "type": "double" },
+      "PaymentAmount": { "type": "double" },
+      "ExpireTime": { "type": "date" },
+      "TradeStatus": { "type": "integer" },
+      "CreateTime": { "type": "date" },
+      "CreateUser": { "type": "keyword" },
+      "UpdateTime": { "type": "date" },
+      "UpdateUser": { "type": "keyword" },
+      "Deleted": { "type": "integer" }
+    }
+  }
+}`,
+}
+
+// ErrIndexNotMigrated 别名不存在或未指向当前版本，旧索引的映射不支持当前的查询及排序
+var ErrIndexNotMigrated = errors.New("订单索引未迁移到当前版本，需执行migrate-index或开启db.es.migrate")
+
+// OrderIndexManager 管理订单索引版本，trade_order作为别名指向当前版本的索引
+type OrderIndexManager struct {
+	es      *elasticsearch.Client
+	alias   string
+	version int
+	logger  *zap.Logger
+}
+
+func versionedIndex(alias string, version int) string {
+	return fmt.Sprintf("%s_v%d", alias, version)
+}
+
+func readError(res interface{ String() string }) error {
+	return fmt.Errorf("elastic请求失败: %s", res.String())
+}
+
+func (m *OrderIndexManager) indexExists(ctx context.Context, index string) (bool, error) {
+	res, err := m.es.Indices.Exists([]string{index}, m.es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, readError(res)
+	}
+}
+
+// aliasIndices 返回别名当前指向的索引，别名不存在时返回空
+func (m *OrderIndexManager) aliasIndices(ctx context.Context) ([]string, error) {
+	res, err := m.es.Indices.GetAlias(m.es.Indices.GetAlias.WithName(m.alias), m.es.Indices.GetAlias.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, readError(res)
+	}
+
+	var ret map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+
+	indices := make([]string, 0, len(ret))
+	for index := range ret {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+func (m *OrderIndexManager) createIndex(ctx context.Context, index string, version int) error {
+	mapping, ok := orderIndexMappings[version]
+	if !ok {
+		return fmt.Errorf("索引映射版本不存在: %d", version)
+	}
+
+	res, err := m.es.Indices.Create(index,
+		m.es.Indices.Create.WithBody(strings.NewReader(mapping)),
+		m.es.Indices.Create.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return readError(res)
+	}
+	return nil
+}
+
+// reindex 复制旧索引的数据，since不为空时只复制此后修改的订单，覆盖目标索引中的旧数据
+func (m *OrderIndexManager) reindex(ctx context.Context, sources []string, dest string, since *LocalTime) error {
+	source := map[string]any{"index": sources}
+	if since != nil {
+		source["query"] = rangeClause("UpdateTime", timeValue(&since.Time), nil)
+	}
+	body := map[string]any{
+		"source": source,
+		"dest":   map[string]any{"index": dest},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return err
+	}
+
+	res, err := m.es.Reindex(&buf,
+		m.es.Reindex.WithContext(ctx),
+		m.es.Reindex.WithWaitForCompletion(true),
+		m.es.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return readError(res)
+	}
+	return nil
+}
+
+// blockWrite 禁止或恢复索引写入，禁止期间写入elastic失败的订单由增量同步补齐
+func (m *OrderIndexManager) blockWrite(ctx context.Context, index string, block bool) error {
+	body := fmt.Sprintf(`{"index.blocks.write": %t}`, block)
+	res, err := m.es.Indices.PutSettings(strings.NewReader(body),
+		m.es.Indices.PutSettings.WithIndex(index),
+		m.es.Indices.PutSettings.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return readError(res)
+	}
+	return nil
+}
+
+func (m *OrderIndexManager) count(ctx context.Context, index string) (int64, error) {
+	res, err := m.es.Count(m.es.Count.WithIndex(index), m.es.Count.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, readError(res)
+	}
+
+	var ret struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return 0, err
+	}
+	return ret.Count, nil
+}
+
+// switchLegacy 与别名同名的旧索引在切换时删除，无法切换后再补齐：
+// 先禁止旧索引写入，补齐此前的修改并核对文档数一致后才切换，失败时恢复写入
+func (m *OrderIndexManager) switchLegacy(ctx context.Context, target string, since *LocalTime) (err error) {
+	if err := m.blockWrite(ctx, m.alias, true); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if err := m.blockWrite(context.Background(), m.alias, false); err != nil {
+			m.logger.Error("恢复订单索引写入失败", zap.String("index", m.alias), zap.Error(err))
+		}
+	}()
+
+	if err := m.reindex(ctx, []string{m.alias}, target, since); err != nil {
+		return err
+	}
+
+	legacyCount, err := m.count(ctx, m.alias)
+	if err != nil {
+		return err
+	}
+	targetCount, err := m.count(ctx, target)
+	if err != nil {
+		return err
+	}
+	if legacyCount != targetCount {
+		return fmt.Errorf("订单索引文档数不一致，未切换别名: %s为%d，%s为%d", m.alias, legacyCount, target, targetCount)
+	}
+
+	return m.switchAlias(ctx, nil, true, target)
+}
+
+// switchAlias 在一次请求中原子地把别名从旧索引切换到新索引，legacy为与别名同名的旧索引
+func (m *OrderIndexManager) switchAlias(ctx context.Context, old []string, legacy bool, target string) error {
+	var actions []any
+	if legacy {
+		actions = append(actions, map[string]any{"remove_index": map[string]any{"index": m.alias}})
+	}
+	for _, index := range old {
+		actions = append(actions, map[string]any{"remove": map[string]any{"index": index, "alias": m.alias}})
+	}
+	actions = append(actions, map[string]any{"add": map[string]any{"index": target, "alias": m.alias}})
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(map[string]any{"actions": actions}); err != nil {
+		return err
+	}
+
+	res, err := m.es.Indices.UpdateAliases(&buf, m.es.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return readError(res)
+	}
+	return nil
+}
+
+// Migrate 创建当前版本索引，从旧索引重建数据后切换别名
+func (m *OrderIndexManager) Migrate(ctx context.Context) error {
+
+	target := versionedIndex(m.alias, m.version)
+
+	exists, err := m.indexExists(ctx, target)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		m.logger.Info("创建订单索引", zap.String("index", target))
+		if err := m.createIndex(ctx, target, m.version); err != nil {
+			return err
+		}
+	}
+
+	old, err := m.aliasIndices(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 别名不存在但同名索引存在，说明是未使用别名时创建的旧索引
+	legacy := false
+	if len(old) == 0 {
+		if legacy, err = m.indexExists(ctx, m.alias); err != nil {
+			return err
+		}
+	}
+
+	var sources []string
+	for _, index := range old {
+		if index != target {
+			sources = append(sources, index)
+		}
+	}
+	if legacy {
+		sources = append(sources, m.alias)
+	}
+
+	if len(sources) == 0 {
+		if len(old) == 0 {
+			m.logger.Info("创建订单索引别名", zap.String("alias", m.alias), zap.String("index", target))
+			return m.switchAlias(ctx, nil, false, target)
+		}
+		return nil
+	}
+
+	start := NewLocalTime(time.Now())
+	m.logger.Info("重建订单索引", zap.Strings("source", sources), zap.String("dest", target))
+	if err := m.reindex(ctx, sources, target, nil); err != nil {
+		return err
+	}
+
+	// 重建期间修改的订单在切换前重新复制，切换后只需补齐很短时间内的修改
+	catchUp := NewLocalTime(time.Now())
+	if err := m.reindex(ctx, sources, target, start); err != nil {
+		return err
+	}
+
+	if legacy {
+		if err := m.switchLegacy(ctx, target, catchUp); err != nil {
+			return err
+		}
+		m.logger.Info("切换订单索引别名", zap.String("alias", m.alias), zap.String("index", target))
+		return nil
+	}
+
+	if err := m.switchAlias(ctx, old, false, target); err != nil {
+		return err
+	}
+	m.logger.Info("切换订单索引别名", zap.String("alias", m.alias), zap.String("index", target))
+
+	// 补齐切换前写入旧索引的修改，旧索引保留以便回滚
+	if err := m.reindex(ctx, sources, target, catchUp); err != nil {
+		m.logger.Warn("补齐订单索引失败", zap.Strings("source", sources), zap.Error(err))
+	}
+
+	return nil
+}
+
+// Check 确认别名指向当前版本的索引
+func (m *OrderIndexManager) Check(ctx context.Context) error {
+	indices, err := m.aliasIndices(ctx)
+	if err != nil {
+		return err
+	}
+
+	target := versionedIndex(m.alias, m.version)
+	for _, index := range indices {
+		if index == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: 别名%s指向%v", ErrIndexNotMigrated, m.alias, indices)
+}
+
+func NewOrderIndexManager(conf config.EsConfig, es *elasticsearch.Client, lc fx.Lifecycle, logger *zap.Logger) *OrderIndexManager {
+	m := &OrderIndexManager{es: es, alias: orderIndex, version: orderIndexVersion, logger: logger.Named("dao")}
+
+	// 启动时迁移受fx启动超时限制，数据量较大时应使用migrate-index命令
+	if conf.Migrate {
+		lc.Append(fx.Hook{
+			OnStart: m.Migrate,
+		})
+		return m
+	}
+
+	// 索引未迁移时查询排序全部失败，启动失败；elastic不可用时查询降级到数据库，不阻止启动
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			err := m.Check(ctx)
+			if errors.Is(err, ErrIndexNotMigrated) {
+				return err
+			}
+			if err != nil {
+				m.logger.Warn("检查订单索引失败", zap.Error(err))
+			}
+			return nil
+		},
+	})
+
+	return m
+}
+
+func ProvideOrderIndex() fx.Option {
+	return fx.Options(fx.Provide(NewOrderIndexManager), fx.Invoke(func(*OrderIndexManager) {}))
+}
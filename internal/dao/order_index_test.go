@@ -0,0 +1,34 @@
+package dao
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOrderIndexMapping(t *testing.T) {
+
+	for version, mapping := range orderIndexMappings {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(mapping), &m); err != nil {
+			t.Errorf("索引映射版本%d格式错误 %v", version, err)
+		}
+	}
+
+	var m struct {
+		Mappings struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(orderIndexMappings[orderIndexVersion]), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	// 当前版本需覆盖订单的全部字段
+	typ := reflect.TypeOf(TradeOrder{})
+	for i := 0; i < typ.NumField(); i++ {
+		if _, ok := m.Mappings.Properties[typ.Field(i).Name]; !ok {
+			t.Errorf("索引映射缺少字段 %s", typ.Field(i).Name)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package dao
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// orderSortFields 允许排序的字段及对应的数据库列
+var orderSortFields = map[string]string{
+	"TradeNo":        "trade_order.trade_no",
+	"TradeStatus":    "trade_order.trade_status",
+	"TotalAmount":    "trade_order.total_amount",
+	"DiscountAmount": "trade_order.discount_amount",
+	"PaymentAmount":  "trade_order.payment_amount",
+	"ExpireTime":     "trade_order.expire_time",
+	"CreateTime":     "trade_order.create_time",
+	"UpdateTime":     "trade_order.update_time",
+}
+
+type OrderSort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseOrderSort 解析形如 CreateTime:desc,TotalAmount:asc 的排序参数
+func ParseOrderSort(sort string) ([]OrderSort, error) {
+	var ret []OrderSort
+	for _, item := range strings.Split(sort, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		field, dir, _ := strings.Cut(item, ":")
+		if _, ok := orderSortFields[field]; !ok {
+			return nil, fmt.Errorf("不支持的排序字段: %s", field)
+		}
+
+		switch strings.ToLower(dir) {
+		case "", "asc":
+			ret = append(ret, OrderSort{Field: field})
+		case "desc":
+			ret = append(ret, OrderSort{Field: field, Desc: true})
+		default:
+			return nil, fmt.Errorf("不支持的排序方向: %s", dir)
+		}
+	}
+	return ret, nil
+}
+
+// OrderQuery 订单查询条件，零值字段不参与过滤
+type OrderQuery struct {
+	TradeNo        uint64
+	UserId         uint64
+	UserCode       string
+	Nickname       string
+	Subject        string
+	TradeStatus    []int
+	CreateTimeFrom *time.Time
+	CreateTimeTo   *time.Time
+	ExpireTimeFrom *time.Time
+	ExpireTimeTo   *time.Time
+	TotalAmountMin *float64
+	TotalAmountMax *float64
+	PaymentMin     *float64
+	PaymentMax     *float64
+	Sort           []OrderSort
+}
+
+func rangeClause(field string, gte, lte any) map[string]any {
+	r := make(map[string]any)
+	if gte != nil {
+		r["gte"] = gte
+	}
+	if lte != nil {
+		r["lte"] = lte
+	}
+	return map[string]any{"range": map[string]any{field: r}}
+}
+
+func timeValue(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+func floatValue(f *float64) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+func (q *OrderQuery) filters() []any {
+	filter := []any{
+		map[string]any{"term": map[string]any{"Deleted": 0}},
+	}
+
+	if q.TradeNo != 0 {
+		filter = append(filter, map[string]any{"term": map[string]any{"TradeNo": strconv.FormatUint(q.TradeNo, 10)}})
+	}
+	if q.UserId != 0 {
+		filter = append(filter, map[string]any{"term": map[string]any{"UserId": strconv.FormatUint(q.UserId, 10)}})
+	}
+	if q.UserCode != "" {
+		filter = append(filter, map[string]any{"term": map[string]any{"UserCode": q.UserCode}})
+	}
+	if q.Nickname != "" {
+		filter = append(filter, map[string]any{"term": map[string]any{"Nickname": q.Nickname}})
+	}
+	if len(q.TradeStatus) > 0 {
+		filter = append(filter, map[string]any{"terms": map[string]any{"TradeStatus": q.TradeStatus}})
+	}
+	if q.CreateTimeFrom != nil || q.CreateTimeTo != nil {
+		filter = append(filter, rangeClause("CreateTime", timeValue(q.CreateTimeFrom), timeValue(q.CreateTimeTo)))
+	}
+	if q.ExpireTimeFrom != nil || q.ExpireTimeTo != nil {
+		filter = append(filter, rangeClause("ExpireTime", timeValue(q.ExpireTimeFrom), timeValue(q.ExpireTimeTo)))
+	}
+	if q.TotalAmountMin != nil || q.TotalAmountMax != nil {
+		filter = append(filter, rangeClause("TotalAmount", floatValue(q.TotalAmountMin), floatValue(q.TotalAmountMax)))
+	}
+	if q.PaymentMin != nil || q.PaymentMax != nil {
+		filter = append(filter, rangeClause("PaymentAmount", floatValue(q.PaymentMin), floatValue(q.PaymentMax)))
+	}
+
+	return filter
+}
+
+// esQuery 过滤条件放在filter中不参与评分，仅标题全文检索参与评分
+func (q *OrderQuery) esQuery() map[string]any {
+	boolQuery := map[string]any{
+		"filter": q.filters(),
+	}
+	if q.Subject != "" {
+		boolQuery["must"] = []any{
+			map[string]any{"match": map[string]any{"Subject": q.Subject}},
+		}
+	}
+	return map[string]any{"bool": boolQuery}
+}
+
+// esSort 未指定排序时全文检索按相关度排序，否则按订单号倒序，订单号始终作为最后的排序字段保证分页稳定
+func (q *OrderQuery) esSort() []any {
+	var sort []any
+	hasTradeNo := false
+	for _, s := range q.Sort {
+		order := "asc"
+		if s.Desc {
+			order = "desc"
+		}
+		sort = append(sort, map[string]any{s.Field: map[string]any{"order": order}})
+		hasTradeNo = hasTradeNo || s.Field == "TradeNo"
+	}
+
+	if len(q.Sort) == 0 && q.Subject != "" {
+		sort = append(sort, map[string]any{"_score": map[string]any{"order": "desc"}})
+	}
+	if !hasTradeNo {
+		sort = append(sort, map[string]any{"TradeNo": map[string]any{"order": "desc"}})
+	}
+	return sort
+}
+
+// cursorSort 未指定排序时游标分页按创建时间、订单号倒序，数据库可按相同顺序键集分页
+func (q *OrderQuery) cursorSort() []any {
+	if !q.keyset() {
+		return q.esSort()
+	}
+	return []any{
+		map[string]any{"CreateTime": map[string]any{"order": "desc"}},
+		map[string]any{"TradeNo": map[string]any{"order": "desc"}},
+	}
+}
+
+// keyset 游标分页是否可由数据库按键集继续
+func (q *OrderQuery) keyset() bool {
+	return len(q.Sort) == 0
+}
+
+// likeEscaper 转义模糊匹配中的通配符，标题按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// where 与esQuery等价的数据库查询条件，标题检索退化为模糊匹配
+func (q *OrderQuery) where(db *gorm.DB) *gorm.DB {
+	if q.TradeNo != 0 {
+		db = db.Where("trade_order.trade_no = ?", q.TradeNo)
+	}
+	if q.UserId != 0 {
+		db = db.Where("trade_order.user_id = ?", q.UserId)
+	}
+	if q.UserCode != "" {
+		db = db.Where("tus.user_code = ?", q.UserCode)
+	}
+	if q.Nickname != "" {
+		db = db.Where("tus.nickname = ?", q.Nickname)
+	}
+	if q.Subject != "" {
+		db = db.Where(`trade_order.subject LIKE ? ESCAPE '\\'`, "%"+escapeLike(q.Subject)+"%")
+	}
+	if len(q.TradeStatus) > 0 {
+		db = db.Where("trade_order.trade_status IN ?", q.TradeStatus)
+	}
+	if q.CreateTimeFrom != nil {
+		db = db.Where("trade_order.create_time >= ?", q.CreateTimeFrom.Format(timeFormat))
+	}
+	if q.CreateTimeTo != nil {
+		db = db.Where("trade_order.create_time <= ?", q.CreateTimeTo.Format(timeFormat))
+	}
+	if q.ExpireTimeFrom != nil {
+		db = db.Where("trade_order.expire_time >= ?", q.ExpireTimeFrom.Format(timeFormat))
+	}
+	if q.ExpireTimeTo != nil {
+		db = db.Where("trade_order.expire_time <= ?", q.ExpireTimeTo.Format(timeFormat))
+	}
+	if q.TotalAmountMin != nil {
+		db = db.Where("trade_order.total_amount >= ?", *q.TotalAmountMin)
+	}
+	if q.TotalAmountMax != nil {
+		db = db.Where("trade_order.total_amount <= ?", *q.TotalAmountMax)
+	}
+	if q.PaymentMin != nil {
+		db = db.Where("trade_order.payment_amount >= ?", *q.PaymentMin)
+	}
+	if q.PaymentMax != nil {
+		db = db.Where("trade_order.payment_amount <= ?", *q.PaymentMax)
+	}
+	return db
+}
+
+func (q *OrderQuery) order(db *gorm.DB) *gorm.DB {
+	hasTradeNo := false
+	for _, s := range q.Sort {
+		column := orderSortFields[s.Field]
+		if s.Desc {
+			column += " desc"
+		}
+		db = db.Order(column)
+		hasTradeNo = hasTradeNo || s.Field == "TradeNo"
+	}
+	if !hasTradeNo {
+		db = db.Order("trade_order.trade_no desc")
+	}
+	return db
+}
+
+// Digest 查询条件摘要，相同条件得到相同摘要，用于缓存key
+func (q *OrderQuery) Digest() string {
+	return q.digest(true)
+}
+
+// FilterDigest 不含排序的条件摘要，用于与排序无关的统计缓存
+func (q *OrderQuery) FilterDigest() string {
+	return q.digest(false)
+}
+
+func (q *OrderQuery) digest(withSort bool) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "tradeNo=%d;userId=%d;userCode=%q;nickname=%q;subject=%q;status=%v;",
+		q.TradeNo, q.UserId, q.UserCode, q.Nickname, q.Subject, q.TradeStatus)
+	fmt.Fprintf(&b, "create=%v~%v;expire=%v~%v;total=%v~%v;payment=%v~%v",
+		timeValue(q.CreateTimeFrom), timeValue(q.CreateTimeTo), timeValue(q.ExpireTimeFrom), timeValue(q.ExpireTimeTo),
+		floatValue(q.TotalAmountMin), floatValue(q.TotalAmountMax), floatValue(q.PaymentMin), floatValue(q.PaymentMax))
+	if withSort {
+		fmt.Fprintf(&b, ";sort=%v", q.Sort)
+	}
+
+	sum := sha1.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
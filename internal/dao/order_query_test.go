@@ -0,0 +1,85 @@
+package dao
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOrderSort(t *testing.T) {
+
+	sort, err := ParseOrderSort("CreateTime:desc, TotalAmount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sort) != 2 || sort[0] != (OrderSort{Field: "CreateTime", Desc: true}) || sort[1] != (OrderSort{Field: "TotalAmount"}) {
+		t.Error("排序解析结果不一致", sort)
+	}
+
+	for _, s := range []string{"Subject:asc", "CreateTime:up"} {
+		if _, err := ParseOrderSort(s); err == nil {
+			t.Error("非法排序参数未报错", s)
+		}
+	}
+}
+
+func TestOrderQueryEs(t *testing.T) {
+
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	min := 10.0
+	q := &OrderQuery{UserId: 1, Subject: "手机", TradeStatus: []int{1, 2}, CreateTimeFrom: &from, PaymentMin: &min}
+
+	body, err := json.Marshal(map[string]any{"query": q.esQuery(), "sort": q.esSort()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`{"term":{"Deleted":0}}`,
+		`{"term":{"UserId":"1"}}`,
+		`{"terms":{"TradeStatus":[1,2]}}`,
+		`{"range":{"CreateTime":{"gte":"2022-06-01T00:00:00Z"}}}`,
+		`{"range":{"PaymentAmount":{"gte":10}}}`,
+		`"must":[{"match":{"Subject":"手机"}}]`,
+		`"sort":[{"_score":{"order":"desc"}},{"TradeNo":{"order":"desc"}}]`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("查询条件缺少 %s: %s", want, body)
+		}
+	}
+}
+
+func TestOrderQueryDigest(t *testing.T) {
+
+	a := &OrderQuery{UserId: 1, TradeStatus: []int{1}}
+	b := &OrderQuery{UserId: 1, TradeStatus: []int{1}}
+	c := &OrderQuery{UserId: 1, TradeStatus: []int{2}}
+
+	if a.Digest() != b.Digest() {
+		t.Error("相同条件摘要不一致")
+	}
+	if a.Digest() == c.Digest() {
+		t.Error("不同条件摘要相同")
+	}
+
+	sorted := &OrderQuery{UserId: 1, TradeStatus: []int{1}, Sort: []OrderSort{{Field: "TotalAmount"}}}
+	if a.Digest() == sorted.Digest() || a.FilterDigest() != sorted.FilterDigest() {
+		t.Error("统计摘要不应包含排序")
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+
+	for s, want := range map[string]string{
+		"手机":   "手机",
+		"100%": `100\%`,
+		"a_b":  `a\_b`,
+		`a\b`:  `a\\b`,
+		`\%_`:  `\\\%\_`,
+	} {
+		if got := escapeLike(s); got != want {
+			t.Errorf("模糊匹配转义不一致 %q %q", got, want)
+		}
+	}
+}
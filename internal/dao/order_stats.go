@@ -0,0 +1,174 @@
+package dao
+
+import (
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStatsInterval = "day"
+	defaultStatsTop      = 10
+)
+
+type StatusBucket struct {
+	TradeStatus   int     `json:"tradeStatus"`
+	Count         int64   `json:"count"`
+	PaymentAmount float64 `json:"paymentAmount"`
+}
+
+type DateBucket struct {
+	Date          string  `json:"date"`
+	Count         int64   `json:"count"`
+	PaymentAmount float64 `json:"paymentAmount"`
+}
+
+type UserBucket struct {
+	UserId        string  `json:"userId"`
+	Count         int64   `json:"count"`
+	PaymentAmount float64 `json:"paymentAmount"`
+}
+
+type OrderStats struct {
+	Count          int64          `json:"count"`
+	TotalAmount    float64        `json:"totalAmount"`
+	DiscountAmount float64        `json:"discountAmount"`
+	PaymentAmount  float64        `json:"paymentAmount"`
+	ByStatus       []StatusBucket `json:"byStatus"`
+	Histogram      []DateBucket   `json:"histogram"`
+	TopUsers       []UserBucket   `json:"topUsers"`
+}
+
+type valueAgg struct {
+	Value float64 `json:"value"`
+}
+
+type bucketAgg struct {
+	Key           any      `json:"key"`
+	KeyAsString   string   `json:"key_as_string"`
+	DocCount      int64    `json:"doc_count"`
+	PaymentAmount valueAgg `json:"payment_amount"`
+}
+
+type statsResponse struct {
+	Hits struct {
+		Total *TotalHit `json:"total"`
+	} `json:"hits"`
+	Aggregations struct {
+		TotalAmount    valueAgg `json:"total_amount"`
+		DiscountAmount valueAgg `json:"discount_amount"`
+		PaymentAmount  valueAgg `json:"payment_amount"`
+		ByStatus       struct {
+			Buckets []bucketAgg `json:"buckets"`
+		} `json:"by_status"`
+		Histogram struct {
+			Buckets []bucketAgg `json:"buckets"`
+		} `json:"histogram"`
+		TopUsers struct {
+			Buckets []bucketAgg `json:"buckets"`
+		} `json:"top_users"`
+	} `json:"aggregations"`
+}
+
+func sumAgg(field string) map[string]any {
+	return map[string]any{"sum": map[string]any{"field": field}}
+}
+
+func statsAggs(interval string, top int) map[string]any {
+	payment := map[string]any{"payment_amount": sumAgg("PaymentAmount")}
+
+	return map[string]any{
+		"total_amount":    sumAgg("TotalAmount"),
+		"discount_amount": sumAgg("DiscountAmount"),
+		"payment_amount":  sumAgg("PaymentAmount"),
+		"by_status": map[string]any{
+			"terms": map[string]any{"field": "TradeStatus", "size": 50},
+			"aggs":  payment,
+		},
+		"histogram": map[string]any{
+			"date_histogram": map[string]any{
+				"field":             "CreateTime",
+				"calendar_interval": interval,
+				"format":            "yyyy-MM-dd HH:mm:ss",
+				"min_doc_count":     1,
+			},
+			"aggs": payment,
+		},
+		"top_users": map[string]any{
+			"terms": map[string]any{
+				"field": "UserId",
+				"size":  top,
+				"order": map[string]any{"payment_amount": "desc"},
+			},
+			"aggs": payment,
+		},
+	}
+}
+
+// OrderStats 按查询条件统计金额合计、状态分布、下单时间分布及消费金额最高的用户
+func (dao *OrderDao) OrderStats(q *OrderQuery, interval string, top int) (*OrderStats, error) {
+
+	if interval == "" {
+		interval = defaultStatsInterval
+	}
+	if top <= 0 {
+		top = defaultStatsTop
+	}
+
+	if !dao.breaker.Allow() {
+		return nil, ErrSearchUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dao.timeout())
+	defer cancel()
+
+	var ret statsResponse
+	err := dao.searchRaw(ctx, map[string]interface{}{
+		"size":             0,
+		"query":            q.esQuery(),
+		"aggs":             statsAggs(interval, top),
+		"track_total_hits": true,
+	}, &ret, orderIndex)
+	if err != nil && !IsBadRequest(err) {
+		dao.breaker.Failure()
+		dao.logger.Warn("统计订单失败", zap.String("breaker", dao.breaker.State()), zap.Error(err))
+		return nil, err
+	}
+	dao.breaker.Success()
+	if err != nil {
+		return nil, err
+	}
+
+	aggs := &ret.Aggregations
+	stats := &OrderStats{
+		TotalAmount:    aggs.TotalAmount.Value,
+		DiscountAmount: aggs.DiscountAmount.Value,
+		PaymentAmount:  aggs.PaymentAmount.Value,
+		ByStatus:       make([]StatusBucket, 0, len(aggs.ByStatus.Buckets)),
+		Histogram:      make([]DateBucket, 0, len(aggs.Histogram.Buckets)),
+		TopUsers:       make([]UserBucket, 0, len(aggs.TopUsers.Buckets)),
+	}
+	if ret.Hits.Total != nil {
+		stats.Count = ret.Hits.Total.Value
+	}
+
+	for _, b := range aggs.ByStatus.Buckets {
+		status, _ := b.Key.(float64)
+		stats.ByStatus = append(stats.ByStatus, StatusBucket{TradeStatus: int(status), Count: b.DocCount, PaymentAmount: b.PaymentAmount.Value})
+	}
+	for _, b := range aggs.Histogram.Buckets {
+		stats.Histogram = append(stats.Histogram, DateBucket{Date: b.KeyAsString, Count: b.DocCount, PaymentAmount: b.PaymentAmount.Value})
+	}
+	for _, b := range aggs.TopUsers.Buckets {
+		userId, ok := b.Key.(string)
+		if !ok {
+			if f, isFloat := b.Key.(float64); isFloat {
+				userId = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		}
+		stats.TopUsers = append(stats.TopUsers, UserBucket{UserId: userId, Count: b.DocCount, PaymentAmount: b.PaymentAmount.Value})
+	}
+
+	return stats, nil
+}
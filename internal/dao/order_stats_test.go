@@ -0,0 +1,37 @@
+package dao
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatsResponse(t *testing.T) {
+
+	body := `{
+  "hits": {"total": {"value": 3, "relation": "eq"}},
+  "aggregations": {
+    "total_amount": {"value": 30},
+    "discount_amount": {"value": 3},
+    "payment_amount": {"value": 27},
+    "by_status": {"buckets": [{"key": 1, "doc_count": 2, "payment_amount": {"value": 18}}]},
+    "histogram": {"buckets": [{"key": 1654041600000, "key_as_string": "2022-06-01 00:00:00", "doc_count": 3, "payment_amount": {"value": 27}}]},
+    "top_users": {"buckets": [{"key": "10001", "doc_count": 3, "payment_amount": {"value": 27}}]}
+  }
+}`
+
+	var ret statsResponse
+	if err := json.Unmarshal([]byte(body), &ret); err != nil {
+		t.Fatal(err)
+	}
+
+	aggs := ret.Aggregations
+	if ret.Hits.Total.Value != 3 || aggs.PaymentAmount.Value != 27 {
+		t.Error("统计结果解析不一致", ret)
+	}
+	if len(aggs.ByStatus.Buckets) != 1 || aggs.ByStatus.Buckets[0].PaymentAmount.Value != 18 {
+		t.Error("状态分布解析不一致", aggs.ByStatus)
+	}
+	if aggs.Histogram.Buckets[0].KeyAsString != "2022-06-01 00:00:00" || aggs.TopUsers.Buckets[0].Key != "10001" {
+		t.Error("时间分布或用户排行解析不一致", aggs.Histogram, aggs.TopUsers)
+	}
+}
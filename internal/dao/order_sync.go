@@ -0,0 +1,360 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"goweb/internal/leader"
+
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/go-redis/redis"
+	"github.com/knadh/koanf"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSyncInterval = 10 * time.Second
+	defaultSyncBatch    = 500
+	defaultSyncRetry    = 3
+	defaultSyncOverlap  = time.Minute
+)
+
+// syncCheckpointKey 检查点保存在redis，leader切换后新的leader从同一位置继续
+const syncCheckpointKey = "sync:order:checkpoint"
+
+// syncFullKey 已按sync.order.full清空过检查点，配置保持开启时重启不再全量同步，关闭后删除
+const syncFullKey = "sync:order:full"
+
+// SyncCheckpoint 增量同步高水位，UpdateTime相同时以TradeNo区分先后
+type SyncCheckpoint struct {
+	UpdateTime string `json:"updateTime"`
+	TradeNo    string `json:"tradeNo"`
+}
+
+// errNotLeader 同步过程中失去leader，由新的leader继续
+var errNotLeader = errors.New("已不是leader")
+
+// OrderSyncer 将数据库中变更的订单增量同步到elastic
+type OrderSyncer struct {
+	dao      *OrderDao
+	rdb      redis.UniversalClient
+	elector  *leader.Elector
+	interval time.Duration
+	batch    int
+	retry    int
+	overlap  time.Duration
+	logger   *zap.Logger
+
+	mu sync.Mutex
+}
+
+func (s *OrderSyncer) loadCheckpoint() (*SyncCheckpoint, error) {
+	var cp SyncCheckpoint
+
+	// 没有检查点时从头同步
+	data, err := s.rdb.Get(syncCheckpointKey).Bytes()
+	if err == redis.Nil {
+		return &cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (s *OrderSyncer) saveCheckpoint(cp *SyncCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(syncCheckpointKey, data, 0).Err()
+}
+
+// after 是否在o之后，订单号为数字字符串，长度不同时按长度比较
+func (cp *SyncCheckpoint) after(o *SyncCheckpoint) bool {
+	if cp.UpdateTime != o.UpdateTime {
+		return cp.UpdateTime > o.UpdateTime
+	}
+	if len(cp.TradeNo) != len(o.TradeNo) {
+		return len(cp.TradeNo) > len(o.TradeNo)
+	}
+	return cp.TradeNo > o.TradeNo
+}
+
+// scanFrom 每次同步从检查点前overlap开始，update_time早于检查点但提交较晚的订单也能同步
+func scanFrom(cp *SyncCheckpoint, overlap time.Duration) (*SyncCheckpoint, error) {
+	if cp.UpdateTime == "" || overlap <= 0 {
+		return cp, nil
+	}
+
+	t, err := time.Parse(timeFormat, cp.UpdateTime)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncCheckpoint{UpdateTime: t.Add(-overlap).Format(timeFormat)}, nil
+}
+
+// changedOrders 查询检查点之后变更的订单，包含已删除订单以同步删除标记
+func (dao *OrderDao) changedOrders(cp *SyncCheckpoint, size int) ([]*TradeOrder, error) {
+
+	var orders []*TradeOrder
+
+	db := dao.orderQuery()
+	if cp.UpdateTime != "" {
+		db = db.Where("trade_order.update_time > ? OR (trade_order.update_time = ? AND trade_order.trade_no > ?)",
+			cp.UpdateTime, cp.UpdateTime, cp.TradeNo)
+	}
+
+	ret := db.Order("trade_order.update_time, trade_order.trade_no").Limit(size).Find(&orders)
+	if ret.Error != nil {
+		return nil, ret.Error
+	}
+
+	return orders, nil
+}
+
+// bulkIndex 批量写入elastic，返回写入失败的订单
+func (dao *OrderDao) bulkIndex(ctx context.Context, orders []*TradeOrder) ([]*TradeOrder, error) {
+
+	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{Index: orderIndex, Client: dao.es})
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var failed []*TradeOrder
+
+	for _, order := range orders {
+		order := order
+
+		body, err := json.Marshal(order)
+		if err != nil {
+			return nil, err
+		}
+
+		err = indexer.Add(ctx, esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: order.TradeNo,
+			Body:       bytes.NewReader(body),
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				if err != nil {
+					dao.logger.Warn("写入elastic失败", zap.String("tradeNo", order.TradeNo), zap.Error(err))
+				} else {
+					dao.logger.Warn("写入elastic失败", zap.String("tradeNo", order.TradeNo), zap.String("type", res.Error.Type), zap.String("reason", res.Error.Reason))
+				}
+				mu.Lock()
+				failed = append(failed, order)
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := indexer.Close(ctx); err != nil {
+		return nil, err
+	}
+
+	return failed, nil
+}
+
+func (s *OrderSyncer) indexWithRetry(ctx context.Context, orders []*TradeOrder) error {
+
+	pending := orders
+	for attempt := 0; ; attempt++ {
+		failed, err := s.dao.bulkIndex(ctx, pending)
+		if err == nil && len(failed) == 0 {
+			return nil
+		}
+		if err == nil {
+			pending = failed
+		}
+
+		if attempt >= s.retry {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%d条订单同步失败", len(pending))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+// verify 写入前确认fencing token仍然有效，失去租约的旧leader不再写入elastic及检查点
+func (s *OrderSyncer) verify(token int64) error {
+	ok, err := s.elector.Verify(token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotLeader
+	}
+	return nil
+}
+
+// Sync 从检查点前overlap开始增量同步，直到没有新的变更，重叠部分重复写入不影响结果；
+// ctx为leader任务的上下文，每批写入前校验其中的fencing token
+func (s *OrderSyncer) Sync(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := leader.TokenFrom(ctx)
+
+	saved, err := s.loadCheckpoint()
+	if err != nil {
+		return 0, err
+	}
+	cp, err := scanFrom(saved, s.overlap)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for {
+		orders, err := s.dao.changedOrders(cp, s.batch)
+		if err != nil {
+			return count, err
+		}
+
+		if len(orders) == 0 {
+			return count, nil
+		}
+
+		if err := s.verify(token); err != nil {
+			return count, err
+		}
+		if err := s.indexWithRetry(ctx, orders); err != nil {
+			return count, err
+		}
+
+		last := orders[len(orders)-1]
+		cp = &SyncCheckpoint{TradeNo: last.TradeNo}
+		if last.UpdateTime != nil {
+			cp.UpdateTime = last.UpdateTime.Format(timeFormat)
+		}
+		// 重叠部分不回退检查点
+		if cp.after(saved) {
+			if err := s.verify(token); err != nil {
+				return count, err
+			}
+			if err := s.saveCheckpoint(cp); err != nil {
+				return count, err
+			}
+			saved = cp
+		}
+
+		count += len(orders)
+		if len(orders) < s.batch {
+			return count, nil
+		}
+	}
+}
+
+// fullSync sync.order.full开启后只清空一次检查点，关闭后删除标记，再次开启时重新全量同步
+func (s *OrderSyncer) fullSync(full bool) error {
+	if !full {
+		return s.rdb.Del(syncFullKey).Err()
+	}
+
+	first, err := s.rdb.SetNX(syncFullKey, time.Now().Format(timeFormat), 0).Result()
+	if err != nil || !first {
+		return err
+	}
+
+	s.logger.Info("全量同步订单到elastic")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveCheckpoint(&SyncCheckpoint{}); err != nil {
+		// 清空失败时下次启动重试
+		s.rdb.Del(syncFullKey)
+		return err
+	}
+	return nil
+}
+
+// run 只在leader上运行，失去leader时ctx取消
+func (s *OrderSyncer) run(ctx context.Context) {
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		count, err := s.Sync(ctx)
+		if errors.Is(err, errNotLeader) {
+			s.logger.Warn("leader已变更，停止同步订单", zap.Int64("token", leader.TokenFrom(ctx)), zap.Int("count", count))
+			return
+		}
+		if err != nil {
+			s.logger.Error("同步订单到elastic失败", zap.Int("count", count), zap.Error(err))
+		} else if count > 0 {
+			s.logger.Info("同步订单到elastic", zap.Int("count", count), zap.Duration("elapsed", time.Since(start)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func NewOrderSyncer(k *koanf.Koanf, dao *OrderDao, rdb redis.UniversalClient, elector *leader.Elector, lc fx.Lifecycle, logger *zap.Logger) *OrderSyncer {
+
+	s := &OrderSyncer{
+		dao:      dao,
+		rdb:      rdb,
+		elector:  elector,
+		interval: defaultSyncInterval,
+		batch:    defaultSyncBatch,
+		retry:    defaultSyncRetry,
+		overlap:  defaultSyncOverlap,
+		logger:   logger.Named("dao"),
+	}
+	if k.Exists("sync.order.interval") {
+		s.interval = k.Duration("sync.order.interval")
+	}
+	if k.Exists("sync.order.batch") {
+		s.batch = k.Int("sync.order.batch")
+	}
+	if k.Exists("sync.order.retry") {
+		s.retry = k.Int("sync.order.retry")
+	}
+	if k.Exists("sync.order.overlap") {
+		s.overlap = k.Duration("sync.order.overlap")
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := s.fullSync(k.Bool("sync.order.full")); err != nil {
+				return err
+			}
+
+			// 多个实例只由leader同步
+			elector.Run("order-sync", s.run)
+			return nil
+		},
+	})
+
+	return s
+}
+
+func ProvideOrderSync() fx.Option {
+	return fx.Options(fx.Provide(NewOrderSyncer), fx.Invoke(func(*OrderSyncer) {}))
+}
@@ -0,0 +1,33 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanFrom(t *testing.T) {
+
+	cp := &SyncCheckpoint{UpdateTime: "2022-06-15 12:00:00", TradeNo: "1536972017172901888"}
+
+	from, err := scanFrom(cp, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.UpdateTime != "2022-06-15 11:59:00" || from.TradeNo != "" {
+		t.Error("同步起点应为检查点前一分钟", from)
+	}
+
+	if from, _ := scanFrom(&SyncCheckpoint{}, time.Minute); from.UpdateTime != "" {
+		t.Error("没有检查点时应从头同步", from)
+	}
+	if from, _ := scanFrom(cp, 0); *from != *cp {
+		t.Error("不重叠时应从检查点开始", from)
+	}
+
+	if !(&SyncCheckpoint{UpdateTime: cp.UpdateTime, TradeNo: "10000000000000000000"}).after(cp) {
+		t.Error("订单号较长的检查点应在后")
+	}
+	if from.after(cp) || !cp.after(&SyncCheckpoint{}) {
+		t.Error("检查点先后顺序不一致")
+	}
+}
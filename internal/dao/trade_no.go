@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"goweb/internal/config"
+)
+
+// 与已有订单号一致的snowflake格式: 41位毫秒时间戳 | 10位节点 | 12位序列号
+const (
+	snowflakeEpoch    int64 = 1288834974657
+	snowflakeNodeBits       = 10
+	snowflakeSeqBits        = 12
+	snowflakeMaxNode        = -1 ^ (-1 << snowflakeNodeBits)
+	snowflakeMaxSeq         = -1 ^ (-1 << snowflakeSeqBits)
+)
+
+type TradeNoGenerator struct {
+	mu       sync.Mutex
+	node     int64
+	lastTime int64
+	seq      int64
+}
+
+func (g *TradeNoGenerator) Next() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now().UnixMilli() - snowflakeEpoch
+	if now < g.lastTime {
+		// 时钟回拨时沿用上次时间戳，避免生成重复订单号
+		now = g.lastTime
+	}
+
+	if now == g.lastTime {
+		g.seq = (g.seq + 1) & snowflakeMaxSeq
+		if g.seq == 0 {
+			for now <= g.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli() - snowflakeEpoch
+			}
+		}
+	} else {
+		g.seq = 0
+	}
+
+	g.lastTime = now
+
+	return uint64(now<<(snowflakeNodeBits+snowflakeSeqBits) | g.node<<snowflakeSeqBits | g.seq)
+}
+
+func NewTradeNoGenerator(conf config.ServerConfig) (*TradeNoGenerator, error) {
+	node := conf.Node
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("节点编号超出范围 [0, %d]: %d", snowflakeMaxNode, node)
+	}
+	return &TradeNoGenerator{node: node}, nil
+}
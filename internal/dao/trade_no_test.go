@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"testing"
+
+	"goweb/internal/config"
+)
+
+func TestTradeNoGenerator(t *testing.T) {
+
+	g, err := NewTradeNoGenerator(config.ServerConfig{Node: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last uint64
+	seen := make(map[uint64]struct{})
+	for i := 0; i < 10000; i++ {
+		no := g.Next()
+		if no <= last {
+			t.Fatal("订单号未递增", no, last)
+		}
+		if _, ok := seen[no]; ok {
+			t.Fatal("订单号重复", no)
+		}
+		if (no>>snowflakeSeqBits)&snowflakeMaxNode != 3 {
+			t.Fatal("订单号节点编号不一致", no)
+		}
+		seen[no] = struct{}{}
+		last = no
+	}
+}
+
+func TestTradeNoGeneratorNode(t *testing.T) {
+
+	if _, err := NewTradeNoGenerator(config.ServerConfig{Node: 1024}); err == nil {
+		t.Error("节点编号超出范围未报错")
+	}
+}
@@ -0,0 +1,149 @@
+package di
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"goweb/internal/config"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/rawbytes"
+)
+
+const (
+	envPrefix         = "GOWEB_"
+	defaultConfigFile = "config/config.yaml"
+)
+
+//go:embed default.yaml
+var defaultConfig []byte
+
+// ConfOptions 配置文件路径及环境，均为空时从工作目录向上查找config/config.yaml
+type ConfOptions struct {
+	Path string
+	Env  string
+}
+
+// ParseFlags 解析--config及--env，返回其余参数
+func ParseFlags(args []string) (ConfOptions, []string, error) {
+	var opts ConfOptions
+
+	fs := flag.NewFlagSet("goweb", flag.ContinueOnError)
+	fs.StringVar(&opts.Path, "config", "", "配置文件路径")
+	fs.StringVar(&opts.Env, "env", os.Getenv(envPrefix+"ENV"), "运行环境，加载同目录下的config.<env>.yaml")
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+	return opts, fs.Args(), nil
+}
+
+// findConfig 从工作目录向上查找默认配置文件，未找到时返回空
+func findConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, defaultConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// envOverlay 环境配置文件与配置文件位于同一目录
+func envOverlay(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// confFiles 配置文件及环境配置文件路径，未找到配置文件或未指定环境时为空
+func confFiles(opts ConfOptions) (string, string) {
+	path := opts.Path
+	if path == "" {
+		path = findConfig()
+	}
+	if opts.Env == "" {
+		return path, ""
+	}
+	if path == "" {
+		return "", envOverlay(defaultConfigFile, opts.Env)
+	}
+	return path, envOverlay(path, opts.Env)
+}
+
+// envKey GOWEB_DB_TIDB_PASSWORD对应db.tidb.password，key中的下划线写作双下划线，
+// 如GOWEB_CACHE_KEYSPACE__EVENTS对应cache.keyspace_events
+func envKey(name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, envPrefix))
+	parts := strings.Split(name, "__")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(part, "_", ".")
+	}
+	return strings.Join(parts, "_")
+}
+
+// loadEnv 环境变量覆盖已有配置，原值为列表时按逗号拆分
+func loadEnv(k *koanf.Koanf) error {
+	return k.Load(env.ProviderWithValue(envPrefix, ".", func(name, value string) (string, any) {
+		if name == envPrefix+"ENV" {
+			return "", nil
+		}
+
+		key := envKey(name)
+		if _, ok := k.Get(key).([]any); ok {
+			return key, strings.Split(value, ",")
+		}
+		return key, value
+	}), nil)
+}
+
+// InitConf 依次加载内置默认配置、配置文件、环境配置文件及GOWEB_环境变量，后加载的覆盖先加载的，
+// 最后解析file:、env:等密钥引用
+func InitConf(opts ConfOptions) (*koanf.Koanf, error) {
+	var k = koanf.New(".")
+	if err := k.Load(rawbytes.Provider(defaultConfig), yaml.Parser()); err != nil {
+		fmt.Printf("加载默认配置失败 %v", err)
+		return nil, err
+	}
+
+	path, overlay := confFiles(opts)
+	if path != "" {
+		if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
+			fmt.Printf("加载配置失败 %v", err)
+			return nil, err
+		}
+	}
+
+	if overlay != "" {
+		if _, err := os.Stat(overlay); err == nil {
+			if err := k.Load(file.Provider(overlay), yaml.Parser()); err != nil {
+				fmt.Printf("加载环境配置失败 %v", err)
+				return nil, err
+			}
+		}
+	}
+
+	if err := loadEnv(k); err != nil {
+		fmt.Printf("加载环境变量配置失败 %v", err)
+		return nil, err
+	}
+
+	if err := config.ResolveSecrets(k); err != nil {
+		fmt.Printf("解析配置密钥失败 %v", err)
+		return nil, err
+	}
+	return k, nil
+}
@@ -0,0 +1,60 @@
+package di
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvKey(t *testing.T) {
+
+	for name, want := range map[string]string{
+		"GOWEB_DB_TIDB_PASSWORD":       "db.tidb.password",
+		"GOWEB_CACHE_KEYSPACE__EVENTS": "cache.keyspace_events",
+		"GOWEB_IMPORT_MAX__ROWS":       "import.max_rows",
+	} {
+		if got := envKey(name); got != want {
+			t.Error("环境变量对应的配置项不正确", name, got)
+		}
+	}
+}
+
+func TestInitConf(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	os.WriteFile(path, []byte("server:\n  port: 9000\ndb:\n  tidb:\n    host: db.local\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.prod.yaml"), []byte("db:\n  tidb:\n    host: db.prod\n"), 0644)
+
+	t.Setenv("GOWEB_DB_TIDB_PASSWORD", "secret")
+	t.Setenv("GOWEB_DB_REDIS_ADDRS", "a:6379,b:6379")
+
+	k, err := InitConf(ConfOptions{Path: path, Env: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Int("server.port") != 9000 {
+		t.Error("配置文件应覆盖默认配置", k.Int("server.port"))
+	}
+	if k.String("server.host") != "0.0.0.0" {
+		t.Error("配置文件未设置时应使用默认配置", k.String("server.host"))
+	}
+	if k.String("db.tidb.host") != "db.prod" {
+		t.Error("环境配置文件应覆盖配置文件", k.String("db.tidb.host"))
+	}
+	if k.String("db.tidb.password") != "secret" {
+		t.Error("环境变量应覆盖配置文件", k.String("db.tidb.password"))
+	}
+	if addrs := k.Strings("db.redis.addrs"); len(addrs) != 2 || addrs[1] != "b:6379" {
+		t.Error("列表配置应按逗号拆分", addrs)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+
+	opts, args, err := ParseFlags([]string{"--config", "/etc/goweb.yaml", "--env", "prod", "migrate-index"})
+	if err != nil || opts.Path != "/etc/goweb.yaml" || opts.Env != "prod" || len(args) != 1 || args[0] != "migrate-index" {
+		t.Error("解析命令行参数失败", opts, args, err)
+	}
+}
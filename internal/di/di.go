@@ -10,27 +10,18 @@ import (
 	"time"
 
 	"goweb/internal/build"
+	"goweb/internal/config"
+	"goweb/internal/logging"
 
 	"github.com/gin-gonic/gin"
 	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-func InitConf() (*koanf.Koanf, error) {
-	var k = koanf.New(".")
-	if err := k.Load(file.Provider("config/config.yaml"), yaml.Parser()); err != nil {
-		fmt.Printf("加载配置失败 %v", err)
-		return nil, err
-	}
-	return k, nil
-}
-
-func NewLogWriter(k *koanf.Koanf) io.Writer {
-	f, err := os.OpenFile(k.String("log.file"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+func NewLogWriter(conf config.LogConfig) io.Writer {
+	f, err := os.OpenFile(conf.File, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 
 	if err != nil {
 		fmt.Printf("创建日志文件失败: %v", err)
@@ -43,7 +34,25 @@ func NewLogWriter(k *koanf.Koanf) io.Writer {
 	return writer
 }
 
-func NewLogger(writer io.Writer) *zap.Logger {
+// NewLogLevels 全局及各模块的日志级别，可在运行时修改
+func NewLogLevels(conf config.LogConfig) *logging.Levels {
+	return logging.NewLevels(conf.Level, conf.Modules)
+}
+
+// WatchLogLevel 配置文件中的日志级别修改后立即生效，覆盖通过管理接口设置的级别
+func WatchLogLevel(store *config.Store, levels *logging.Levels, logger *zap.Logger) {
+	store.Subscribe(func(k *koanf.Koanf) {
+		conf, err := config.NewLogConfig(k)
+		if err != nil {
+			logger.Warn("日志配置无效，保留当前级别", zap.Error(err))
+			return
+		}
+		levels.Apply(conf.Level, conf.Modules)
+		logger.Info("修改日志级别", zap.Stringer("level", conf.Level), zap.Any("modules", conf.Modules))
+	}, "log.level", "log.modules")
+}
+
+func NewLogger(writer io.Writer, levels *logging.Levels) *zap.Logger {
 
 	encoderConfig := zap.NewProductionEncoderConfig()
 	timeFormat := "2006/01/02 15:04:05.000"
@@ -68,14 +77,14 @@ func NewLogger(writer io.Writer) *zap.Logger {
 	}
 	logWriter := zapcore.AddSync(writer)
 
-	core := zapcore.NewCore(encoder, logWriter, zapcore.DebugLevel)
+	core := logging.NewCore(zapcore.NewCore(encoder, logWriter, zapcore.DebugLevel), levels)
 
 	return zap.New(core, zap.AddCaller())
 }
 
-func NewServer(k *koanf.Koanf, router *gin.Engine, lc fx.Lifecycle, logger *zap.Logger) *http.Server {
+func NewServer(conf config.ServerConfig, router *gin.Engine, lc fx.Lifecycle, logger *zap.Logger) *http.Server {
 
-	srv := &http.Server{Addr: fmt.Sprintf("%s:%d", k.String("server.host"), k.Int("server.port")), Handler: router}
+	srv := &http.Server{Addr: conf.Addr(), Handler: router}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			ln, err := net.Listen("tcp", srv.Addr)
@@ -93,12 +102,12 @@ func NewServer(k *koanf.Koanf, router *gin.Engine, lc fx.Lifecycle, logger *zap.
 	return srv
 }
 
-func ProvideConfig() fx.Option {
-	return fx.Provide(InitConf)
+func ProvideConfig(opts ConfOptions) fx.Option {
+	return fx.Options(fx.Supply(opts), fx.Provide(InitConf, NewConfigStore), config.ProvideConfig())
 }
 
 func ProvideLogger() fx.Option {
-	return fx.Provide(NewLogWriter, NewLogger)
+	return fx.Options(fx.Provide(NewLogWriter, NewLogLevels, NewLogger), fx.Invoke(WatchLogLevel))
 }
 
 func ProvideServer() fx.Option {
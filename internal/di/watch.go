@@ -0,0 +1,115 @@
+package di
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goweb/internal/config"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/knadh/koanf"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// reloadDelay 编辑器保存及k8s更新ConfigMap时会连续产生多个事件，合并后只加载一次
+const reloadDelay = 200 * time.Millisecond
+
+// watchDirs 监听配置文件所在目录，文件被替换或经符号链接更新时仍能收到事件
+func watchDirs(opts ConfOptions) []string {
+	path, overlay := confFiles(opts)
+
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, file := range []string{path, overlay} {
+		if file == "" {
+			continue
+		}
+		if dir := filepath.Dir(file); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// relevant 配置文件本身或k8s ConfigMap更新时替换的..data等符号链接
+func relevant(opts ConfOptions, name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, "..") {
+		return true
+	}
+	path, overlay := confFiles(opts)
+	return (path != "" && base == filepath.Base(path)) || (overlay != "" && base == filepath.Base(overlay))
+}
+
+// watchConfig 配置文件变化后重新加载全部配置，加载失败时保留当前配置
+func watchConfig(w *fsnotify.Watcher, opts ConfOptions, store *config.Store, logger *zap.Logger) {
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if relevant(opts, event.Name) {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			logger.Warn("监听配置文件失败", zap.Error(err))
+		case <-timer.C:
+			k, err := InitConf(opts)
+			if err != nil {
+				logger.Warn("重新加载配置失败，保留当前配置", zap.Error(err))
+				continue
+			}
+			store.Update(k)
+		}
+	}
+}
+
+// NewConfigStore 启动后监听配置文件，没有配置文件时只使用启动时的配置
+func NewConfigStore(opts ConfOptions, k *koanf.Koanf, lc fx.Lifecycle, logger *zap.Logger) *config.Store {
+	logger = logger.Named("config")
+	store := config.NewStore(k, logger)
+
+	var w *fsnotify.Watcher
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			dirs := watchDirs(opts)
+			if len(dirs) == 0 {
+				return nil
+			}
+
+			var err error
+			if w, err = fsnotify.NewWatcher(); err != nil {
+				logger.Warn("监听配置文件失败", zap.Error(err))
+				return nil
+			}
+			for _, dir := range dirs {
+				if err := w.Add(dir); err != nil {
+					logger.Warn("监听配置目录失败", zap.String("dir", dir), zap.Error(err))
+				}
+			}
+			go watchConfig(w, opts, store, logger)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if w == nil {
+				return nil
+			}
+			return w.Close()
+		},
+	})
+
+	return store
+}
@@ -0,0 +1,46 @@
+package di
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/knadh/koanf"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestConfigStoreWatch(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("cache:\n  stats:\n    ttl: 1m\n"), 0644)
+
+	opts := ConfOptions{Path: path}
+	k, err := InitConf(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc := fxtest.NewLifecycle(t)
+	store := NewConfigStore(opts, k, lc, zap.NewNop())
+
+	reloaded := make(chan time.Duration, 1)
+	store.Subscribe(func(k *koanf.Koanf) { reloaded <- k.Duration("cache.stats.ttl") }, "cache.stats")
+
+	lc.RequireStart()
+	defer lc.Stop(context.Background())
+
+	os.WriteFile(path, []byte("cache:\n  stats:\n    ttl: 5m\n"), 0644)
+
+	select {
+	case ttl := <-reloaded:
+		if ttl != 5*time.Minute {
+			t.Error("重新加载的配置不正确", ttl)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("修改配置文件后未重新加载")
+	}
+}
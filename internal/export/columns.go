@@ -0,0 +1,101 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"goweb/internal/dao"
+)
+
+const (
+	LangZh = "zh"
+	LangEn = "en"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+type Column struct {
+	Name    string
+	Kind    CellKind
+	Headers map[string]string
+	Value   func(o *dao.TradeOrder) Cell
+}
+
+func text(v string) Cell {
+	return Cell{Value: v}
+}
+
+func money(v float64) Cell {
+	return Cell{Value: strconv.FormatFloat(v, 'f', 2, 64), Kind: CellMoney}
+}
+
+func localTime(t *dao.LocalTime) Cell {
+	if t == nil {
+		return text("")
+	}
+	return text(t.Format(timeFormat))
+}
+
+// Columns 可导出的列，顺序即默认导出顺序
+var Columns = []*Column{
+	{"TradeNo", CellText, map[string]string{LangZh: "订单号", LangEn: "Trade No"}, func(o *dao.TradeOrder) Cell { return text(o.TradeNo) }},
+	{"UserId", CellText, map[string]string{LangZh: "用户编号", LangEn: "User ID"}, func(o *dao.TradeOrder) Cell { return text(o.UserId) }},
+	{"UserCode", CellText, map[string]string{LangZh: "用户代码", LangEn: "User Code"}, func(o *dao.TradeOrder) Cell { return text(o.UserCode) }},
+	{"Nickname", CellText, map[string]string{LangZh: "昵称", LangEn: "Nickname"}, func(o *dao.TradeOrder) Cell { return text(o.Nickname) }},
+	{"Subject", CellText, map[string]string{LangZh: "订单标题", LangEn: "Subject"}, func(o *dao.TradeOrder) Cell { return text(o.Subject) }},
+	{"TotalAmount", CellMoney, map[string]string{LangZh: "订单金额", LangEn: "Total Amount"}, func(o *dao.TradeOrder) Cell { return money(o.TotalAmount) }},
+	{"DiscountAmount", CellMoney, map[string]string{LangZh: "优惠金额", LangEn: "Discount Amount"}, func(o *dao.TradeOrder) Cell { return money(o.DiscountAmount) }},
+	{"PaymentAmount", CellMoney, map[string]string{LangZh: "实付金额", LangEn: "Payment Amount"}, func(o *dao.TradeOrder) Cell { return money(o.PaymentAmount) }},
+	{"ExpireTime", CellText, map[string]string{LangZh: "过期时间", LangEn: "Expire Time"}, func(o *dao.TradeOrder) Cell { return localTime(o.ExpireTime) }},
+	{"TradeStatus", CellText, map[string]string{LangZh: "订单状态", LangEn: "Trade Status"}, func(o *dao.TradeOrder) Cell { return text(strconv.Itoa(o.TradeStatus)) }},
+	{"CreateTime", CellText, map[string]string{LangZh: "创建时间", LangEn: "Create Time"}, func(o *dao.TradeOrder) Cell { return localTime(o.CreateTime) }},
+	{"CreateUser", CellText, map[string]string{LangZh: "创建人", LangEn: "Create User"}, func(o *dao.TradeOrder) Cell { return text(o.CreateUser) }},
+	{"UpdateTime", CellText, map[string]string{LangZh: "更新时间", LangEn: "Update Time"}, func(o *dao.TradeOrder) Cell { return localTime(o.UpdateTime) }},
+	{"UpdateUser", CellText, map[string]string{LangZh: "更新人", LangEn: "Update User"}, func(o *dao.TradeOrder) Cell { return text(o.UpdateUser) }},
+}
+
+var columnIndex = func() map[string]*Column {
+	m := make(map[string]*Column, len(Columns))
+	for _, c := range Columns {
+		m[c.Name] = c
+	}
+	return m
+}()
+
+// ParseColumns 解析逗号分隔的列名，为空时导出全部列
+func ParseColumns(names string) ([]*Column, error) {
+	if strings.TrimSpace(names) == "" {
+		return Columns, nil
+	}
+
+	var cols []*Column
+	for _, name := range strings.Split(names, ",") {
+		col, ok := columnIndex[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("不支持的导出列: %s", name)
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+func Headers(cols []*Column, lang string) []string {
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		header, ok := col.Headers[lang]
+		if !ok {
+			header = col.Headers[LangZh]
+		}
+		headers[i] = header
+	}
+	return headers
+}
+
+func Row(cols []*Column, order *dao.TradeOrder) []Cell {
+	cells := make([]Cell, len(cols))
+	for i, col := range cols {
+		cells[i] = col.Value(order)
+	}
+	return cells
+}
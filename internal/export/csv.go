@@ -0,0 +1,54 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+type csvWriter struct {
+	w   io.Writer
+	csv *csv.Writer
+}
+
+func newCsvWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: w, csv: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) WriteHeader(headers []string) error {
+	// 写入BOM，避免Excel打开中文乱码
+	if _, err := c.w.Write([]byte("\xef\xbb\xbf")); err != nil {
+		return err
+	}
+	return c.csv.Write(headers)
+}
+
+// escapeFormula 以这些字符开头的文本会被Excel当作公式执行，加单引号前缀按文本显示
+func escapeFormula(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// WriteRow 金额为程序格式化的数字，不需要转义
+func (c *csvWriter) WriteRow(cells []Cell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		if cell.Kind == CellMoney {
+			record[i] = cell.Value
+		} else {
+			record[i] = escapeFormula(cell.Value)
+		}
+	}
+	return c.csv.Write(record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.csv.Flush()
+	return c.csv.Error()
+}
+
+func (c *csvWriter) Close() error {
+	return c.Flush()
+}
@@ -0,0 +1,548 @@
+package export
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"goweb/internal/cache"
+	"goweb/internal/dao"
+
+	"github.com/go-redis/redis"
+	"github.com/knadh/koanf"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultJobDir       = "data/export"
+	defaultJobWorkers   = 2
+	defaultJobQueue     = 100
+	defaultJobBatch     = 1000
+	defaultJobRetention = 7 * 24 * time.Hour
+	defaultJobCleanup   = time.Hour
+)
+
+// forwardedHeader 转发到任务所在节点的下载请求，该节点不再转发
+const forwardedHeader = "X-Export-Forwarded"
+
+// jobKey 导出任务hash，field为任务编号，value为任务json
+const jobKey = "hashmap:export_job"
+
+type JobState string
+
+const (
+	JobPending JobState = "pending"
+	JobRunning JobState = "running"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+)
+
+var (
+	ErrJobNotFound = errors.New("导出任务不存在")
+	ErrQueueFull   = errors.New("导出任务队列已满")
+	// ErrJobRemote 导出文件在其他节点，下载请求需转发到Job.Addr
+	ErrJobRemote = errors.New("导出文件在其他节点")
+)
+
+// Job 导出任务，Offset与Cursor为检查点：暂存文件的有效长度及其对应的查询位置
+type Job struct {
+	Id         string           `json:"id"`
+	State      JobState         `json:"state"`
+	Format     string           `json:"format"`
+	Columns    string           `json:"columns"`
+	Lang       string           `json:"lang"`
+	Query      *dao.OrderQuery  `json:"query"`
+	Rows       int              `json:"rows"`
+	Offset     int64            `json:"offset"`
+	Cursor     *dao.OrderCursor `json:"cursor,omitempty"`
+	Node       string           `json:"node"`
+	Addr       string           `json:"addr,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	CreateTime time.Time        `json:"createTime"`
+	UpdateTime time.Time        `json:"updateTime"`
+}
+
+// JobManager 异步导出任务，任务状态保存在redis，文件写入本节点目录，重启后从检查点继续；
+// addr为其他节点访问本节点的地址，文件不在当前节点时下载请求转发到任务所在节点
+type JobManager struct {
+	dao       *dao.OrderDao
+	cache     *cache.Cache
+	dir       string
+	workers   int
+	batch     int
+	retention time.Duration
+	cleanup   time.Duration
+	node      string
+	addr      string
+	logger    *zap.Logger
+
+	queue  chan string
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (m *JobManager) save(job *Job) error {
+	job.UpdateTime = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return m.cache.Put(jobKey, job.Id, string(data))
+}
+
+// Get 查询导出任务，任务状态由执行节点更新，直接读取redis
+func (m *JobManager) Get(id string) (*Job, error) {
+	v, err := m.cache.GetRemote(jobKey, id)
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(v), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Submit 创建导出任务并放入队列
+func (m *JobManager) Submit(q *dao.OrderQuery, format, columns, lang string) (*Job, error) {
+	if _, err := ParseColumns(columns); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &Job{
+		Id:         newJobId(),
+		State:      JobPending,
+		Format:     format,
+		Columns:    columns,
+		Lang:       lang,
+		Query:      q,
+		Node:       m.node,
+		Addr:       m.addr,
+		CreateTime: now,
+	}
+	if err := m.save(job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m.queue <- job.Id:
+		return job, nil
+	default:
+		m.cache.Delete(jobKey, job.Id)
+		return nil, ErrQueueFull
+	}
+}
+
+// FilePath 已完成任务的导出文件，文件在其他节点时返回ErrJobRemote
+func (m *JobManager) FilePath(job *Job) (string, error) {
+	if job.Node != m.node {
+		return "", ErrJobRemote
+	}
+
+	path := m.filePath(job)
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrJobNotFound
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// Forward 将下载请求转发到任务所在节点，该节点地址未知或请求已转发过时返回ErrJobNotFound
+func (m *JobManager) Forward(job *Job, w http.ResponseWriter, r *http.Request) error {
+	if job.Addr == "" || r.Header.Get(forwardedHeader) != "" {
+		return ErrJobNotFound
+	}
+
+	target, err := url.Parse(job.Addr)
+	if err != nil {
+		return err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Header.Set(forwardedHeader, m.node)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		m.logger.Warn("转发导出下载失败", zap.String("id", job.Id), zap.String("node", job.Node), zap.String("addr", job.Addr), zap.Error(err))
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	proxy.ServeHTTP(w, r)
+	return nil
+}
+
+func (m *JobManager) filePath(job *Job) string {
+	return filepath.Join(m.dir, job.Id+"."+job.Format)
+}
+
+// partPath 暂存文件，按csv记录行数据，完成后再转换为目标格式
+func (m *JobManager) partPath(job *Job) string {
+	return filepath.Join(m.dir, job.Id+".part")
+}
+
+func (m *JobManager) work() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			m.run(id)
+		}
+	}
+}
+
+func (m *JobManager) run(id string) {
+	job, err := m.Get(id)
+	if err != nil {
+		m.logger.Error("读取导出任务失败", zap.String("id", id), zap.Error(err))
+		return
+	}
+
+	job.State = JobRunning
+	if err := m.save(job); err != nil {
+		m.logger.Error("更新导出任务失败", zap.String("id", id), zap.Error(err))
+		return
+	}
+
+	err = m.export(job)
+	if m.ctx.Err() != nil {
+		// 服务停止，任务保持running状态，重启后从检查点继续
+		m.logger.Info("导出任务中断", zap.String("id", id), zap.Int("rows", job.Rows))
+		return
+	}
+
+	if err != nil {
+		m.logger.Error("导出任务失败", zap.String("id", id), zap.Int("rows", job.Rows), zap.Error(err))
+		job.State = JobFailed
+		job.Error = err.Error()
+		os.Remove(m.partPath(job))
+	} else {
+		m.logger.Info("导出任务完成", zap.String("id", id), zap.String("format", job.Format), zap.Int("rows", job.Rows))
+		job.State = JobDone
+		job.Cursor = nil
+	}
+
+	if err := m.save(job); err != nil {
+		m.logger.Error("更新导出任务失败", zap.String("id", id), zap.Error(err))
+	}
+}
+
+func (m *JobManager) export(job *Job) error {
+	cols, err := ParseColumns(job.Columns)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+
+	part, err := os.OpenFile(m.partPath(job), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	// 丢弃检查点之后写入的数据，从检查点位置继续查询
+	if err := part.Truncate(job.Offset); err != nil {
+		return err
+	}
+	if _, err := part.Seek(job.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(part)
+	err = m.dao.ScanOrders(m.ctx, job.Query, job.Cursor, m.batch, func(orders []*dao.TradeOrder, after *dao.OrderCursor) error {
+		record := make([]string, len(cols))
+		for _, order := range orders {
+			for i, cell := range Row(cols, order) {
+				record[i] = cell.Value
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if err := part.Sync(); err != nil {
+			return err
+		}
+
+		offset, err := part.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		job.Rows += len(orders)
+		job.Offset = offset
+		job.Cursor = after
+		return m.save(job)
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := m.convert(job, cols, part); err != nil {
+		return err
+	}
+
+	part.Close()
+	return os.Remove(m.partPath(job))
+}
+
+// convert 将暂存数据转换为目标格式，先写临时文件再重命名
+func (m *JobManager) convert(job *Job, cols []*Column, part io.Reader) error {
+	path := m.filePath(job)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	writer, err := NewWriter(job.Format, f)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteHeader(Headers(cols, job.Lang)); err != nil {
+		return err
+	}
+
+	r := csv.NewReader(part)
+	r.FieldsPerRecord = len(cols)
+	cells := make([]Cell, len(cols))
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range record {
+			cells[i] = Cell{Value: v, Kind: cols[i].Kind}
+		}
+		if err := writer.WriteRow(cells); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// localJobs 本节点创建的任务
+func (m *JobManager) localJobs() (map[string]*Job, error) {
+	jobs, err := m.cache.GetAll(jobKey)
+	if err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]*Job)
+	for id, v := range jobs {
+		var job Job
+		if err := json.Unmarshal([]byte(v), &job); err != nil {
+			m.logger.Warn("导出任务格式错误", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		if job.Node == m.node {
+			local[id] = &job
+		}
+	}
+	return local, nil
+}
+
+// clean 删除本节点过期的任务及文件
+func (m *JobManager) clean() error {
+	jobs, err := m.localJobs()
+	if err != nil {
+		return err
+	}
+
+	for id, job := range jobs {
+		if time.Since(job.CreateTime) <= m.retention {
+			continue
+		}
+		// 执行中的任务每批更新UpdateTime，完成后再清理
+		if job.State == JobRunning && time.Since(job.UpdateTime) <= m.retention {
+			continue
+		}
+		os.Remove(m.filePath(job))
+		os.Remove(m.partPath(job))
+		if err := m.cache.Delete(jobKey, id); err != nil {
+			return err
+		}
+		m.logger.Info("清理过期导出任务", zap.String("id", id), zap.Time("createTime", job.CreateTime))
+	}
+	return nil
+}
+
+// cleanLoop 定期清理过期任务，长时间运行的节点也不会积累导出文件
+func (m *JobManager) cleanLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.cleanup)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.clean(); err != nil {
+				m.logger.Warn("清理导出任务失败", zap.Error(err))
+			}
+		}
+	}
+}
+
+// recover 清理过期任务，恢复本节点未完成的任务
+func (m *JobManager) recover() error {
+	if err := m.clean(); err != nil {
+		return err
+	}
+
+	jobs, err := m.localJobs()
+	if err != nil {
+		return err
+	}
+
+	for id, job := range jobs {
+		if job.State != JobPending && job.State != JobRunning {
+			continue
+		}
+		m.logger.Info("恢复导出任务", zap.String("id", id), zap.Int("rows", job.Rows))
+		select {
+		case m.queue <- id:
+		default:
+			m.logger.Warn("导出任务队列已满，任务未恢复", zap.String("id", id))
+		}
+	}
+	return nil
+}
+
+func NewJobManager(k *koanf.Koanf, orderDao *dao.OrderDao, cache *cache.Cache, lc fx.Lifecycle, logger *zap.Logger) (*JobManager, error) {
+
+	m := &JobManager{
+		dao:       orderDao,
+		cache:     cache,
+		dir:       defaultJobDir,
+		workers:   defaultJobWorkers,
+		batch:     defaultJobBatch,
+		retention: defaultJobRetention,
+		cleanup:   defaultJobCleanup,
+		logger:    logger.Named("export"),
+	}
+	if k.Exists("export.dir") {
+		m.dir = k.String("export.dir")
+	}
+	if k.Exists("export.workers") {
+		m.workers = k.Int("export.workers")
+	}
+	if k.Exists("export.batch") {
+		m.batch = k.Int("export.batch")
+	}
+	if k.Exists("export.retention") {
+		m.retention = k.Duration("export.retention")
+	}
+	if k.Exists("export.cleanup") {
+		m.cleanup = k.Duration("export.cleanup")
+	}
+
+	// 任务只在创建节点执行，导出文件保存在该节点本地，节点标识需在重启及重新调度后保持不变，
+	// 主机名会随重新调度变化，任务将无法恢复，因此不作为默认值
+	m.node = strings.TrimSpace(k.String("export.node"))
+	if m.node == "" {
+		return nil, errors.New("缺少配置项export.node")
+	}
+	// 其他节点通过该地址下载本节点的导出文件，如http://10.0.0.1:8080
+	m.addr = strings.TrimRight(strings.TrimSpace(k.String("export.addr")), "/")
+
+	queueSize := defaultJobQueue
+	if k.Exists("export.queue") {
+		queueSize = k.Int("export.queue")
+	}
+	m.queue = make(chan string, queueSize)
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			m.ctx, m.cancel = context.WithCancel(context.Background())
+			if err := m.recover(); err != nil {
+				m.logger.Warn("恢复导出任务失败", zap.Error(err))
+			}
+
+			for i := 0; i < m.workers; i++ {
+				m.wg.Add(1)
+				go m.work()
+			}
+			if m.cleanup > 0 {
+				m.wg.Add(1)
+				go m.cleanLoop()
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			m.cancel()
+
+			done := make(chan struct{})
+			go func() {
+				m.wg.Wait()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+
+	return m, nil
+}
+
+func ProvideJobManager() fx.Option {
+	return fx.Provide(NewJobManager)
+}
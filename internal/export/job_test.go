@@ -0,0 +1,85 @@
+package export
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/knadh/koanf"
+	"go.uber.org/zap"
+)
+
+func TestJobConvert(t *testing.T) {
+
+	m := &JobManager{dir: t.TempDir(), logger: zap.NewNop()}
+	job := &Job{Id: newJobId(), Format: FormatCsv, Columns: "TradeNo,Subject,TotalAmount", Lang: LangEn}
+
+	cols, _ := ParseColumns(job.Columns)
+	part := "1536972017172901888,\"手机, 黑色\",12.50\n1536972017172901889,耳机,3.00\n"
+	if err := m.convert(job, cols, strings.NewReader(part)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.dir, job.Id+".csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\xef\xbb\xbfTrade No,Subject,Total Amount\n" + part
+	if string(data) != want {
+		t.Errorf("导出文件内容不一致\n%q\n%q", data, want)
+	}
+
+	if _, err := m.FilePath(&Job{Id: "missing", Format: FormatCsv}); err != ErrJobNotFound {
+		t.Error("不存在的文件应返回ErrJobNotFound", err)
+	}
+	if _, err := m.FilePath(&Job{Id: job.Id, Format: FormatCsv, Node: "other"}); err != ErrJobRemote {
+		t.Error("其他节点的文件应返回ErrJobRemote", err)
+	}
+
+	if err := m.convert(job, cols, strings.NewReader("1,2\n")); err == nil {
+		t.Error("列数不一致应返回错误")
+	}
+}
+
+func TestJobForward(t *testing.T) {
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(forwardedHeader) == "" {
+			t.Error("转发请求缺少标记")
+		}
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer node.Close()
+
+	m := &JobManager{node: "a", logger: zap.NewNop()}
+	job := &Job{Id: newJobId(), Format: FormatCsv, Node: "b", Addr: node.URL}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/order/exports/"+job.Id+"?download=true", nil)
+	if err := m.Forward(job, w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "/order/exports/"+job.Id+"?download=true" {
+		t.Error("转发地址不一致", w.Body.String())
+	}
+
+	// 已转发的请求不再转发
+	r.Header.Set(forwardedHeader, "c")
+	if err := m.Forward(job, httptest.NewRecorder(), r); err != ErrJobNotFound {
+		t.Error("已转发的请求应返回ErrJobNotFound", err)
+	}
+}
+
+func TestJobManagerNode(t *testing.T) {
+
+	// 节点标识随主机名变化时重启后无法恢复任务，未配置时启动失败
+	k := koanf.New(".")
+	if _, err := NewJobManager(k, nil, nil, nil, zap.NewNop()); err == nil {
+		t.Error("未配置export.node应返回错误")
+	}
+}
@@ -0,0 +1,50 @@
+package export
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	FormatCsv  = "csv"
+	FormatXlsx = "xlsx"
+)
+
+type CellKind int
+
+const (
+	CellText CellKind = iota
+	CellMoney
+)
+
+type Cell struct {
+	Value string
+	Kind  CellKind
+}
+
+// Writer 逐行写出表格，不在内存中缓存数据
+type Writer interface {
+	WriteHeader(headers []string) error
+	WriteRow(cells []Cell) error
+	// Flush 将已写入的行发送到底层输出
+	Flush() error
+	Close() error
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCsv:
+		return newCsvWriter(w), nil
+	case FormatXlsx:
+		return newXlsxWriter(w)
+	default:
+		return nil, fmt.Errorf("不支持的导出格式: %s", format)
+	}
+}
+
+func ContentType(format string) string {
+	if format == FormatXlsx {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
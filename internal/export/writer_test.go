@@ -0,0 +1,124 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"goweb/internal/dao"
+)
+
+func testOrders() []*dao.TradeOrder {
+	return []*dao.TradeOrder{
+		{TradeNo: "1536972017172901888", Subject: "手机, 黑色", TotalAmount: 12.5},
+		{TradeNo: "1536972017172901889", Subject: "<耳机> & 充电器", TotalAmount: 3},
+	}
+}
+
+func TestCsvWriter(t *testing.T) {
+
+	cols, err := ParseColumns("TradeNo,Subject,TotalAmount")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(FormatCsv, &buf)
+	w.WriteHeader(Headers(cols, LangEn))
+	for _, o := range testOrders() {
+		w.WriteRow(Row(cols, o))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\xef\xbb\xbfTrade No,Subject,Total Amount\n" +
+		"1536972017172901888,\"手机, 黑色\",12.50\n" +
+		"1536972017172901889,<耳机> & 充电器,3.00\n"
+	if buf.String() != want {
+		t.Errorf("csv内容不一致 %q", buf.String())
+	}
+
+	for v, want := range map[string]string{
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+1":                       "'+1",
+		"-1":                       "'-1",
+		"@SUM(A1)":                 "'@SUM(A1)",
+		"\tcmd":                    "'\tcmd",
+		"\rcmd":                    "'\rcmd",
+		"手机":                       "手机",
+		"":                         "",
+	} {
+		if got := escapeFormula(v); got != want {
+			t.Errorf("公式转义不一致 %q %q", got, want)
+		}
+	}
+}
+
+func TestXlsxWriter(t *testing.T) {
+
+	cols, _ := ParseColumns("")
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(FormatXlsx, &buf)
+	w.WriteHeader(Headers(cols, LangZh))
+	for _, o := range testOrders() {
+		w.WriteRow(Row(cols, o))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet []byte
+	for _, f := range z.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, _ := f.Open()
+			sheet, _ = io.ReadAll(r)
+			r.Close()
+		}
+	}
+
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Ref   string `xml:"r,attr"`
+				Text  string `xml:"is>t"`
+				Value string `xml:"v"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(sheet, &ws); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ws.Rows) != 3 || len(ws.Rows[0].Cells) != len(Columns) {
+		t.Fatal("工作表行列数不一致", len(ws.Rows))
+	}
+	if ws.Rows[0].Cells[0].Text != "订单号" || ws.Rows[2].Cells[4].Text != "<耳机> & 充电器" {
+		t.Error("工作表文本内容不一致", ws.Rows[0].Cells[0], ws.Rows[2].Cells[4])
+	}
+	if c := ws.Rows[1].Cells[5]; c.Ref != "F2" || c.Value != "12.50" {
+		t.Error("工作表金额内容不一致", c)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("列名不一致 %d: %s", i, got)
+		}
+	}
+
+	if _, err := ParseColumns("TradeNo,Password"); err == nil || !strings.Contains(err.Error(), "Password") {
+		t.Error("非法导出列未报错")
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"net/http"
+
+	"goweb/internal/cache"
+	"goweb/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type AdminHandler struct {
+	levels *logging.Levels
+	cache  *cache.Cache
+	logger *zap.Logger
+}
+
+type LogLevelResult struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+// SetLogLevelParam Module为空时修改全局级别，Level为空时删除模块级别
+type SetLogLevelParam struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+}
+
+func (a *AdminHandler) levelResult() *LogLevelResult {
+	result := &LogLevelResult{Level: a.levels.Global().String(), Modules: make(map[string]string)}
+	for module, level := range a.levels.Modules() {
+		result.Modules[module] = level.String()
+	}
+	return result
+}
+
+func (a *AdminHandler) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, Response[*LogLevelResult]{Code: http.StatusOK, Data: a.levelResult()})
+}
+
+// SetLogLevel 运行时修改日志级别，配置文件中的日志级别修改后会覆盖
+func (a *AdminHandler) SetLogLevel(c *gin.Context) {
+
+	var params SetLogLevelParam
+	if err := c.ShouldBindJSON(&params); err != nil {
+		a.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	if params.Module == "" && params.Level == "" {
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "日志级别无效"})
+		return
+	}
+
+	if params.Level == "" {
+		a.levels.ResetModule(params.Module)
+		a.logger.Info("删除模块日志级别", zap.String("module", params.Module))
+		c.JSON(http.StatusOK, Response[*LogLevelResult]{Code: http.StatusOK, Data: a.levelResult()})
+		return
+	}
+
+	level, err := zapcore.ParseLevel(params.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "日志级别无效"})
+		return
+	}
+
+	if params.Module == "" {
+		a.levels.SetGlobal(level)
+	} else {
+		a.levels.SetModule(params.Module, level)
+	}
+	a.logger.Info("修改日志级别", zap.String("module", params.Module), zap.Stringer("level", level))
+	c.JSON(http.StatusOK, Response[*LogLevelResult]{Code: http.StatusOK, Data: a.levelResult()})
+}
+
+// GetJanitorStats 分页缓存清理任务的运行情况，只有leader上的清理次数会增加
+func (a *AdminHandler) GetJanitorStats(c *gin.Context) {
+	c.JSON(http.StatusOK, Response[cache.JanitorStats]{Code: http.StatusOK, Data: a.cache.JanitorStats()})
+}
+
+func NewAdminHandler(levels *logging.Levels, c *cache.Cache, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{levels: levels, cache: c, logger: logger.Named("handler")}
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"goweb/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// AdminAuth 校验管理接口令牌，未配置令牌时拒绝所有请求
+func AdminAuth(conf config.AdminConfig) gin.HandlerFunc {
+	token := []byte(conf.Token.Value())
+
+	return func(c *gin.Context) {
+		got := []byte(c.GetHeader(adminTokenHeader))
+		if len(token) == 0 || subtle.ConstantTimeCompare(got, token) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, Response[struct{}]{Code: http.StatusForbidden, Message: "无权访问"})
+			return
+		}
+		c.Next()
+	}
+}
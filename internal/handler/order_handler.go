@@ -1,35 +1,219 @@
 package handler
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"goweb/internal/cache"
 	"goweb/internal/dao"
+	"goweb/internal/export"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
+	"github.com/knadh/koanf"
 	"go.uber.org/zap"
 )
 
+// OrderFilterParam 订单查询、统计、导出共用的过滤条件，时间格式为 2006-01-02 15:04:05
+type OrderFilterParam struct {
+	TradeNo        uint64     `form:"tradeNo"`
+	UserId         uint64     `form:"userId"`
+	UserCode       string     `form:"userCode"`
+	Nickname       string     `form:"nickname"`
+	Subject        string     `form:"subject"`
+	TradeStatus    []int      `form:"tradeStatus"`
+	CreateTimeFrom *time.Time `form:"createTimeFrom" time_format:"2006-01-02 15:04:05" time_utc:"1"`
+	CreateTimeTo   *time.Time `form:"createTimeTo" time_format:"2006-01-02 15:04:05" time_utc:"1"`
+	ExpireTimeFrom *time.Time `form:"expireTimeFrom" time_format:"2006-01-02 15:04:05" time_utc:"1"`
+	ExpireTimeTo   *time.Time `form:"expireTimeTo" time_format:"2006-01-02 15:04:05" time_utc:"1"`
+	TotalAmountMin *float64   `form:"totalAmountMin"`
+	TotalAmountMax *float64   `form:"totalAmountMax"`
+	PaymentMin     *float64   `form:"paymentMin"`
+	PaymentMax     *float64   `form:"paymentMax"`
+	Sort           string     `form:"sort"`
+}
+
+func (p *OrderFilterParam) query() (*dao.OrderQuery, error) {
+	sort, err := dao.ParseOrderSort(p.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dao.OrderQuery{
+		TradeNo:        p.TradeNo,
+		UserId:         p.UserId,
+		UserCode:       p.UserCode,
+		Nickname:       p.Nickname,
+		Subject:        p.Subject,
+		TradeStatus:    p.TradeStatus,
+		CreateTimeFrom: p.CreateTimeFrom,
+		CreateTimeTo:   p.CreateTimeTo,
+		ExpireTimeFrom: p.ExpireTimeFrom,
+		ExpireTimeTo:   p.ExpireTimeTo,
+		TotalAmountMin: p.TotalAmountMin,
+		TotalAmountMax: p.TotalAmountMax,
+		PaymentMin:     p.PaymentMin,
+		PaymentMax:     p.PaymentMax,
+		Sort:           sort,
+	}, nil
+}
+
 type GetOrderParam struct {
+	OrderFilterParam
 	PageNumber int    `form:"pageNumber"`
 	PageSize   int    `form:"pageSize"`
-	TradeNo    uint64 `form:"tradeNo"`
-	UserId     uint64 `form:"userId"`
+	Cursor     string `form:"cursor"`
+}
+
+type AddOrderParam struct {
+	UserId         string         `binding:"required,numeric"`
+	Subject        string         `binding:"required,max=256"`
+	TotalAmount    float64        `json:",string" binding:"gte=0"`
+	DiscountAmount float64        `json:",string" binding:"gte=0,ltefield=TotalAmount"`
+	PaymentAmount  float64        `json:",string" binding:"gte=0,ltefield=TotalAmount"`
+	ExpireTime     *dao.LocalTime `binding:"required"`
+	TradeStatus    int            `binding:"gte=0"`
+	CreateUser     string         `binding:"required"`
+}
+
+type UpdateOrderParam struct {
+	TradeNo        string         `binding:"required,numeric"`
+	UpdateTime     *dao.LocalTime `binding:"required"`
+	UpdateUser     string         `binding:"required"`
+	Subject        *string        `binding:"omitempty,max=256"`
+	TotalAmount    *float64       `json:",string" binding:"omitempty,gte=0"`
+	DiscountAmount *float64       `json:",string" binding:"omitempty,gte=0"`
+	PaymentAmount  *float64       `json:",string" binding:"omitempty,gte=0"`
+	ExpireTime     *dao.LocalTime
+	TradeStatus    *int `binding:"omitempty,gte=0"`
+}
+
+func (p *UpdateOrderParam) fields() map[string]any {
+	fields := make(map[string]any)
+	if p.Subject != nil {
+		fields["subject"] = *p.Subject
+	}
+	if p.TotalAmount != nil {
+		fields["total_amount"] = *p.TotalAmount
+	}
+	if p.DiscountAmount != nil {
+		fields["discount_amount"] = *p.DiscountAmount
+	}
+	if p.PaymentAmount != nil {
+		fields["payment_amount"] = *p.PaymentAmount
+	}
+	if p.ExpireTime != nil {
+		fields["expire_time"] = p.ExpireTime
+	}
+	if p.TradeStatus != nil {
+		fields["trade_status"] = *p.TradeStatus
+	}
+	return fields
+}
+
+type DeleteOrderParam struct {
+	TradeNo    string    `uri:"tradeNo" binding:"required,numeric"`
+	UpdateTime time.Time `form:"updateTime" binding:"required"`
+	UpdateUser string    `form:"updateUser" binding:"required"`
 }
 
 type GetOrderResult struct {
+	Total      int64             `json:"total"`
+	Orders     []*dao.TradeOrder `json:"orders"`
+	Source     string            `json:"source"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+// cursorPage 按游标位置缓存的分页，Next与Key都为空表示最后一页
+type cursorPage struct {
 	Total  int64             `json:"total"`
 	Orders []*dao.TradeOrder `json:"orders"`
+	Next   []json.RawMessage `json:"next,omitempty"`
+	Key    *dao.OrderKey     `json:"key,omitempty"`
+}
+
+const sourceCache = "cache"
+
+type OrderStatsParam struct {
+	OrderFilterParam
+	Interval string `form:"interval" binding:"omitempty,oneof=hour day week month quarter year"`
+	Top      int    `form:"top" binding:"omitempty,min=1,max=100"`
+}
+
+const defaultStatsTTL = time.Minute
+
+type ExportOrderParam struct {
+	OrderFilterParam
+	Format  string `form:"format" binding:"omitempty,oneof=csv xlsx"`
+	Columns string `form:"columns"`
+	Lang    string `form:"lang" binding:"omitempty,oneof=zh en"`
 }
 
+const exportBatchSize = 1000
+
 type OrderHandler struct {
-	cache    *cache.Cache
-	orderDao *dao.OrderDao
-	logger   *zap.Logger
+	cache         *cache.Cache
+	orderDao      *dao.OrderDao
+	jobs          *export.JobManager
+	statsTTL      atomic.Int64
+	orderPages    atomic.Pointer[cache.PageSpec[*dao.TradeOrder]]
+	importBatch   int
+	importMaxRows int
+	logger        *zap.Logger
+}
+
+// orderPageTTL 分页缓存的最长保留时间，超过软过期时间后在后台刷新
+const (
+	orderPageTTL       = time.Hour
+	defaultPageSoftTTL = time.Minute
+)
+
+// orderPageGenKey 订单分页缓存的版本号，field为用户编号，0为不限用户的查询；
+// 订单变更时递增不限用户及该用户的版本号，旧版本的分页不再读取，随过期删除，写入无需扫描key
+const orderPageGenKey = "hashmap:order_page_gen"
+
+// orderSortKey 包含用户编号及其版本号，订单变更时只失效不限用户及该用户的分页
+func orderSortKey(q *dao.OrderQuery, gen string) string {
+	return cache.Key("sortset", "order", q.UserId, gen, q.Digest())
+}
+
+// orderCursorKey 游标分页缓存key，不含point in time，相同位置的请求可共享缓存
+func orderCursorKey(q *dao.OrderQuery, gen string, size int, cursor *dao.OrderCursor) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d|%s", size, cursor.AfterKey())))
+	return fmt.Sprintf("cursor:order:%d:%s:%s:%s", q.UserId, gen, q.Digest(), hex.EncodeToString(sum[:8]))
+}
+
+// orderPageGen 查询条件对应的分页缓存版本号
+func (o *OrderHandler) orderPageGen(q *dao.OrderQuery) (string, error) {
+	v, err := o.cache.Get(orderPageGenKey, strconv.FormatUint(q.UserId, 10))
+	if err == redis.Nil {
+		return "0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(v), nil
+}
+
+// invalidateOrderPages 订单新增或变更后失效不限用户及相关用户的全部分页缓存
+func (o *OrderHandler) invalidateOrderPages(userIds ...string) {
+	fields := []string{"0"}
+	for _, userId := range userIds {
+		if uid, err := strconv.ParseUint(userId, 10, 64); err == nil && uid != 0 {
+			fields = append(fields, strconv.FormatUint(uid, 10))
+		}
+	}
+
+	if err := o.cache.Incr(orderPageGenKey, fields...); err != nil {
+		o.logger.Warn("更新订单缓存版本失败", zap.Strings("users", fields), zap.Error(err))
+	}
 }
 
 func (o *OrderHandler) GetHtml(c *gin.Context) {
@@ -47,69 +231,553 @@ func (o *OrderHandler) GetOrder(c *gin.Context) {
 
 	o.logger.Debug("解析查询参数", zap.Any("结果", params))
 
-	offset := params.PageNumber * params.PageSize
-	sortKey := fmt.Sprintf("sortset:order:%d:%d", params.UserId, params.TradeNo)
-	dataKey := "hashmap:order"
-	total, ret, err := o.cache.Range(sortKey, dataKey, int64(offset), int64((params.PageNumber+1)*params.PageSize)-1)
+	query, err := params.query()
+	if err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	if params.Cursor != "" {
+		o.getOrderByCursor(c, query, &params)
+		return
+	}
+
+	load := func() (int64, []*dao.TradeOrder, string, error) {
+		total, orders, from, err := o.orderDao.GetOrder(params.PageNumber, params.PageSize, query)
+		return total, orders, string(from), err
+	}
 
+	var page *cache.Page[*dao.TradeOrder]
+	gen, err := o.orderPageGen(query)
+	if err == nil {
+		offset := int64(params.PageNumber * params.PageSize)
+		page, err = cache.GetOrLoadPage(o.cache, o.orderPages.Load(), orderSortKey(query, gen), offset, offset+int64(params.PageSize)-1, load)
+	} else {
+		// 版本号未知时不读写缓存，避免读到已失效的分页
+		o.logger.Warn("查询订单缓存版本失败", zap.Error(err))
+		page = &cache.Page[*dao.TradeOrder]{}
+		page.Total, page.Items, page.Source, err = load()
+	}
 	if err != nil {
-		o.logger.Warn("查询订单缓存失败", zap.Error(err))
+		o.logger.Error("查询订单失败", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "查询订单失败"})
+		return
 	}
 
-	var orders = make([]*dao.TradeOrder, params.PageSize)
+	source := page.Source
+	if source == "" {
+		source = sourceCache
+	}
 
-	if len(ret) > 0 {
-		for i, v := range ret {
-			var order dao.TradeOrder
+	c.JSON(http.StatusOK, Response[*GetOrderResult]{Code: http.StatusOK, Data: &GetOrderResult{Total: page.Total, Orders: page.Items, Source: source}})
+}
 
-			err = json.Unmarshal([]byte(v), &order)
-			if err != nil {
-				o.logger.Warn("反序列化order失败", zap.Any("order", v), zap.Error(err))
-				continue
-			}
-			orders[i] = &order
+// getOrderByCursor 基于search_after的游标分页，不受from+size的一万条限制，elastic不可用时由数据库按键集继续
+func (o *OrderHandler) getOrderByCursor(c *gin.Context, query *dao.OrderQuery, params *GetOrderParam) {
+
+	cursor, err := dao.ParseOrderCursor(params.Cursor, query)
+	if err != nil || params.PageSize <= 0 {
+		o.logger.Debug("参数解析异常", zap.String("cursor", params.Cursor), zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	var page cursorPage
+	var next *dao.OrderCursor
+	source := sourceCache
+
+	// 版本号未知时不读写缓存
+	var cacheKey string
+	gen, err := o.orderPageGen(query)
+	if err == nil {
+		cacheKey = orderCursorKey(query, gen, params.PageSize, cursor)
+		var v string
+		if v, err = o.cache.GetValue(cacheKey); err == nil {
+			err = json.Unmarshal([]byte(v), &page)
 		}
+	}
 
+	if err == nil {
+		if page.Next != nil || page.Key != nil {
+			next = &dao.OrderCursor{Pit: cursor.Pit, SearchAfter: page.Next, Key: page.Key, Total: page.Total, Digest: cursor.Digest}
+		}
 	} else {
-		total, orders, err = o.orderDao.GetOrder(params.PageNumber, params.PageSize, params.TradeNo, params.UserId)
+		if err != redis.Nil {
+			o.logger.Warn("查询订单缓存失败", zap.String("key", cacheKey), zap.Error(err))
+		}
+
+		var from dao.OrderSource
+		page.Total, page.Orders, next, from, err = o.orderDao.GetOrderAfter(params.PageSize, query, cursor)
+		if errors.Is(err, dao.ErrSearchUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, Response[struct{}]{Code: http.StatusServiceUnavailable, Message: "订单查询暂不可用"})
+			return
+		}
 		if err != nil {
 			o.logger.Error("查询订单失败", zap.Error(err))
 			c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "查询订单失败"})
 			return
 		}
+		source = string(from)
 
-		var orderMap = make(map[string]any, len(orders))
-		var members = make([]redis.Z, len(orders))
-		for i, order := range orders {
-			orderStr, err := json.Marshal(order)
-			if err != nil {
-				o.logger.Warn("序列化订单失败", zap.Error(err))
-				continue
-			}
-
-			members[i] = redis.Z{Score: float64(offset + i), Member: order.TradeNo}
-			orderMap[order.TradeNo] = orderStr
+		if next != nil {
+			page.Next, page.Key = next.SearchAfter, next.Key
+		}
+		if cacheKey == "" {
+			// 不写入缓存
+		} else if pageStr, err := json.Marshal(&page); err != nil {
+			o.logger.Warn("序列化订单失败", zap.Error(err))
+		} else if err := o.cache.PutValue(cacheKey, pageStr, time.Hour); err != nil {
+			o.logger.Warn("写入订单缓存失败", zap.String("key", cacheKey), zap.Error(err))
 		}
-
-		o.cache.PutRange(sortKey, dataKey, members, orderMap, total, time.Hour)
 	}
 
-	c.JSON(http.StatusOK, Response[*GetOrderResult]{Code: http.StatusOK, Data: &GetOrderResult{Total: total, Orders: orders}})
+	result := &GetOrderResult{Total: page.Total, Orders: page.Orders, Source: source}
+	if next != nil {
+		result.NextCursor = next.Encode()
+	}
 
+	c.JSON(http.StatusOK, Response[*GetOrderResult]{Code: http.StatusOK, Data: result})
 }
 
 func (o *OrderHandler) AddOrder(c *gin.Context) {
 
+	var params AddOrderParam
+	if err := c.ShouldBindJSON(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	order := params.order()
+
+	if err := o.orderDao.AddOrder(order); err != nil {
+		o.logger.Error("新增订单失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "新增订单失败"})
+		return
+	}
+
+	o.invalidateOrderPages(order.UserId)
+
+	c.JSON(http.StatusOK, Response[*dao.TradeOrder]{Code: http.StatusOK, Data: order})
 }
 
 func (o *OrderHandler) UpdateOrder(c *gin.Context) {
 
+	var params UpdateOrderParam
+	if err := c.ShouldBindJSON(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	fields := params.fields()
+	if len(fields) == 0 {
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "没有需要更新的字段"})
+		return
+	}
+
+	order, err := o.orderDao.UpdateOrder(params.TradeNo, params.UpdateTime, params.UpdateUser, fields)
+	switch {
+	case errors.Is(err, dao.ErrOrderConflict):
+		c.JSON(http.StatusConflict, Response[*dao.TradeOrder]{Code: http.StatusConflict, Message: "订单已被修改", Data: order})
+		return
+	case errors.Is(err, dao.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, Response[struct{}]{Code: http.StatusNotFound, Message: "订单不存在"})
+		return
+	case errors.Is(err, dao.ErrOrderAmount):
+		c.JSON(http.StatusBadRequest, Response[*dao.TradeOrder]{Code: http.StatusBadRequest, Message: "优惠金额或实付金额不能超过订单总额", Data: order})
+		return
+	case err != nil:
+		o.logger.Error("更新订单失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "更新订单失败"})
+		return
+	}
+
+	// 状态、金额变化会影响按条件过滤的分页
+	o.invalidateOrderPages(order.UserId)
+
+	c.JSON(http.StatusOK, Response[*dao.TradeOrder]{Code: http.StatusOK, Data: order})
 }
 
 func (o *OrderHandler) DeleteOrder(c *gin.Context) {
+	o.setDeleted(c, o.orderDao.DeleteOrder)
+}
+
+func (o *OrderHandler) RestoreOrder(c *gin.Context) {
+	o.setDeleted(c, o.orderDao.RestoreOrder)
+}
 
+func (o *OrderHandler) setDeleted(c *gin.Context, update func(tradeNo string, updateTime *dao.LocalTime, updateUser string) (*dao.TradeOrder, error)) {
+
+	var params DeleteOrderParam
+	if err := c.ShouldBindUri(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+	if err := c.ShouldBindQuery(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	order, err := update(params.TradeNo, &dao.LocalTime{Time: params.UpdateTime}, params.UpdateUser)
+	switch {
+	case errors.Is(err, dao.ErrOrderConflict):
+		c.JSON(http.StatusConflict, Response[*dao.TradeOrder]{Code: http.StatusConflict, Message: "订单已被修改", Data: order})
+		return
+	case errors.Is(err, dao.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, Response[struct{}]{Code: http.StatusNotFound, Message: "订单不存在"})
+		return
+	case err != nil:
+		o.logger.Error("更新订单删除标记失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "更新订单失败"})
+		return
+	}
+
+	// 删除或恢复都会改变列表总数，恢复的订单也需重新出现在列表中
+	o.invalidateOrderPages(order.UserId)
+
+	c.JSON(http.StatusOK, Response[*dao.TradeOrder]{Code: http.StatusOK, Data: order})
 }
 
-func NewOrderHandler(cache *cache.Cache, orderDao *dao.OrderDao, logger *zap.Logger) *OrderHandler {
-	return &OrderHandler{cache: cache, orderDao: orderDao, logger: logger}
+// GetOrderStats 订单统计，结果按查询条件缓存，不随订单变更失效
+func (o *OrderHandler) GetOrderStats(c *gin.Context) {
+
+	var params OrderStatsParam
+	if err := c.ShouldBind(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	query, err := params.query()
+	if err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	// 统计与排序无关，不同排序共享缓存
+	cacheKey := fmt.Sprintf("stats:order:%d:%s:%s:%d", query.UserId, query.FilterDigest(), params.Interval, params.Top)
+
+	var stats dao.OrderStats
+	v, err := o.cache.GetValue(cacheKey)
+	if err == nil {
+		if err = json.Unmarshal([]byte(v), &stats); err == nil {
+			c.JSON(http.StatusOK, Response[*dao.OrderStats]{Code: http.StatusOK, Data: &stats})
+			return
+		}
+	}
+	if err != redis.Nil {
+		o.logger.Warn("查询统计缓存失败", zap.String("key", cacheKey), zap.Error(err))
+	}
+
+	ret, err := o.orderDao.OrderStats(query, params.Interval, params.Top)
+	if errors.Is(err, dao.ErrSearchUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, Response[struct{}]{Code: http.StatusServiceUnavailable, Message: "订单统计暂不可用"})
+		return
+	}
+	if err != nil {
+		o.logger.Error("统计订单失败", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "统计订单失败"})
+		return
+	}
+
+	if statsStr, err := json.Marshal(ret); err != nil {
+		o.logger.Warn("序列化统计结果失败", zap.Error(err))
+	} else if err := o.cache.PutValue(cacheKey, statsStr, time.Duration(o.statsTTL.Load())); err != nil {
+		o.logger.Warn("写入统计缓存失败", zap.String("key", cacheKey), zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, Response[*dao.OrderStats]{Code: http.StatusOK, Data: ret})
+}
+
+// ExportOrder 按查询条件逐批读取订单并直接写入响应，数据不在内存中累积
+func (o *OrderHandler) ExportOrder(c *gin.Context) {
+
+	var params ExportOrderParam
+	if err := c.ShouldBind(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+	if params.Format == "" {
+		params.Format = export.FormatCsv
+	}
+	if params.Lang == "" {
+		params.Lang = export.LangZh
+	}
+
+	query, err := params.query()
+	if err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	cols, err := export.ParseColumns(params.Columns)
+	if err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	var writer export.Writer
+	var rows int
+
+	// 取到第一批数据后才写响应头，之前的错误仍可返回错误码
+	start := func() error {
+		filename := fmt.Sprintf("orders-%s.%s", time.Now().Format("20060102150405"), params.Format)
+		c.Header("Content-Type", export.ContentType(params.Format))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Status(http.StatusOK)
+
+		var err error
+		if writer, err = export.NewWriter(params.Format, c.Writer); err != nil {
+			return err
+		}
+		return writer.WriteHeader(export.Headers(cols, params.Lang))
+	}
+
+	err = o.orderDao.ScanOrders(c.Request.Context(), query, nil, exportBatchSize, func(orders []*dao.TradeOrder, _ *dao.OrderCursor) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		for _, order := range orders {
+			if err := writer.WriteRow(export.Row(cols, order)); err != nil {
+				return err
+			}
+		}
+		rows += len(orders)
+
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+
+	if writer == nil {
+		switch {
+		case errors.Is(err, dao.ErrSearchUnavailable):
+			c.JSON(http.StatusServiceUnavailable, Response[struct{}]{Code: http.StatusServiceUnavailable, Message: "订单导出暂不可用"})
+			return
+		case dao.IsBadRequest(err):
+			o.logger.Debug("导出条件无效", zap.Error(err))
+			c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "导出订单失败"})
+			return
+		case err != nil:
+			o.logger.Error("导出订单失败", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "导出订单失败"})
+			return
+		}
+
+		// 没有符合条件的订单时只输出表头
+		err = start()
+	}
+
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// 响应已开始输出，只能中断连接
+		o.logger.Error("导出订单中断", zap.Int("rows", rows), zap.Error(err))
+		abortResponse(c)
+		return
+	}
+	c.Writer.Flush()
+
+	o.logger.Info("导出订单", zap.String("format", params.Format), zap.Int("rows", rows))
+}
+
+// abortResponse 响应已开始输出时关闭连接，分块传输没有正常结束，客户端不会把截断的文件当作完整下载
+func abortResponse(c *gin.Context) {
+	c.Abort()
+	if conn, _, err := c.Writer.Hijack(); err == nil {
+		conn.Close()
+		return
+	}
+	// 不支持hijack时由net/http中断连接，recovery中间件不处理该panic
+	panic(http.ErrAbortHandler)
+}
+
+// SubmitExport 创建异步导出任务，过滤条件与同步导出相同
+func (o *OrderHandler) SubmitExport(c *gin.Context) {
+
+	var params ExportOrderParam
+	if err := c.ShouldBindQuery(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+	if params.Format == "" {
+		params.Format = export.FormatCsv
+	}
+	if params.Lang == "" {
+		params.Lang = export.LangZh
+	}
+
+	query, err := params.query()
+	if err == nil {
+		_, err = export.ParseColumns(params.Columns)
+	}
+	if err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	job, err := o.jobs.Submit(query, params.Format, params.Columns, params.Lang)
+	if errors.Is(err, export.ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, Response[struct{}]{Code: http.StatusServiceUnavailable, Message: "导出任务过多，请稍后再试"})
+		return
+	}
+	if err != nil {
+		o.logger.Error("创建导出任务失败", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "创建导出任务失败"})
+		return
+	}
+
+	o.logger.Info("创建导出任务", zap.String("id", job.Id), zap.String("format", job.Format))
+	c.JSON(http.StatusAccepted, Response[*export.Job]{Code: http.StatusAccepted, Data: job})
+}
+
+type GetExportParam struct {
+	Id       string `uri:"id" binding:"required,hexadecimal"`
+	Download bool   `form:"download"`
+}
+
+// GetExport 查询导出任务状态，download=true且任务已完成时下载文件
+func (o *OrderHandler) GetExport(c *gin.Context) {
+
+	var params GetExportParam
+	if err := c.ShouldBindUri(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+	if err := c.ShouldBindQuery(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+
+	job, err := o.jobs.Get(params.Id)
+	if errors.Is(err, export.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, Response[struct{}]{Code: http.StatusNotFound, Message: "导出任务不存在"})
+		return
+	}
+	if err != nil {
+		o.logger.Error("查询导出任务失败", zap.String("id", params.Id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "查询导出任务失败"})
+		return
+	}
+
+	if !params.Download {
+		c.JSON(http.StatusOK, Response[*export.Job]{Code: http.StatusOK, Data: job})
+		return
+	}
+
+	if job.State != export.JobDone {
+		c.JSON(http.StatusConflict, Response[*export.Job]{Code: http.StatusConflict, Message: "导出任务未完成", Data: job})
+		return
+	}
+
+	path, err := o.jobs.FilePath(job)
+	if errors.Is(err, export.ErrJobRemote) {
+		// 负载均衡到其他节点时转发到任务所在节点下载
+		err = o.jobs.Forward(job, c.Writer, c.Request)
+		if err == nil {
+			return
+		}
+	}
+	if errors.Is(err, export.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, Response[*export.Job]{Code: http.StatusNotFound, Message: "导出文件不在当前节点或已清理", Data: job})
+		return
+	}
+	if err != nil {
+		o.logger.Error("读取导出文件失败", zap.String("id", job.Id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, Response[struct{}]{Code: http.StatusInternalServerError, Message: "读取导出文件失败"})
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(job.Format))
+	c.FileAttachment(path, fmt.Sprintf("orders-%s.%s", job.CreateTime.Format("20060102150405"), job.Format))
+}
+
+func NewOrderHandler(k *koanf.Koanf, c *cache.Cache, orderDao *dao.OrderDao, jobs *export.JobManager, logger *zap.Logger) (*OrderHandler, error) {
+	o := &OrderHandler{
+		cache:         c,
+		orderDao:      orderDao,
+		jobs:          jobs,
+		importBatch:   defaultImportBatch,
+		importMaxRows: defaultImportMaxRows,
+		logger:        logger.Named("handler"),
+	}
+	if k.Exists("import.batch") {
+		o.importBatch = k.Int("import.batch")
+	}
+	if k.Exists("import.max_rows") {
+		o.importMaxRows = k.Int("import.max_rows")
+	}
+
+	// 缓存配置无效时启动失败
+	statsTTL, pages, err := orderCacheConfig(k)
+	if err != nil {
+		return nil, err
+	}
+	o.statsTTL.Store(int64(statsTTL))
+	o.orderPages.Store(pages)
+
+	return o, nil
+}
+
+// orderCacheConfig 统计缓存时间及订单分页缓存的序列化方式、过期时间
+func orderCacheConfig(k *koanf.Koanf) (time.Duration, *cache.PageSpec[*dao.TradeOrder], error) {
+	statsTTL := defaultStatsTTL
+	if k.Exists("cache.stats.ttl") {
+		statsTTL = k.Duration("cache.stats.ttl")
+	}
+	if statsTTL <= 0 {
+		return 0, nil, fmt.Errorf("cache.stats.ttl必须大于0: %s", k.String("cache.stats.ttl"))
+	}
+
+	softTTL := defaultPageSoftTTL
+	if k.Exists("cache.order.soft_ttl") {
+		softTTL = k.Duration("cache.order.soft_ttl")
+	}
+	if softTTL <= 0 || softTTL > orderPageTTL {
+		return 0, nil, fmt.Errorf("cache.order.soft_ttl必须大于0且不超过%s: %s", orderPageTTL, k.String("cache.order.soft_ttl"))
+	}
+
+	codec := cache.JSON
+	if k.Exists("cache.order.codec") {
+		var err error
+		if codec, err = cache.CodecByName(k.String("cache.order.codec")); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	pages, err := cache.NewPageSpec(codec, func(order *dao.TradeOrder) string { return order.TradeNo }, softTTL, orderPageTTL)
+	if err != nil {
+		return 0, nil, err
+	}
+	return statsTTL, pages, nil
+}
+
+// reload 统计缓存时间及订单分页缓存的配置支持运行时修改，序列化方式修改后旧数据不再读取，随过期删除；
+// 配置无效时保留当前配置
+func (o *OrderHandler) reload(k *koanf.Koanf) {
+	statsTTL, pages, err := orderCacheConfig(k)
+	if err != nil {
+		o.logger.Warn("订单缓存配置无效，保留当前配置", zap.Error(err))
+		return
+	}
+	o.statsTTL.Store(int64(statsTTL))
+	o.orderPages.Store(pages)
 }
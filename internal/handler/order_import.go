@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goweb/internal/dao"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+const (
+	importFormatCsv    = "csv"
+	importFormatNdjson = "ndjson"
+
+	defaultImportBatch   = 500
+	defaultImportMaxRows = 10000
+)
+
+var errImportTooLarge = errors.New("导入行数超过限制")
+
+// importColumns csv表头可使用的列，与AddOrderParam字段同名
+var importColumns = map[string]bool{
+	"UserId": true, "Subject": true, "TotalAmount": true, "DiscountAmount": true,
+	"PaymentAmount": true, "ExpireTime": true, "TradeStatus": true, "CreateUser": true,
+}
+
+type ImportOrderParam struct {
+	Format string `form:"format" binding:"omitempty,oneof=csv ndjson"`
+	DryRun bool   `form:"dryRun"`
+}
+
+// ImportRowError 导入失败的行，Line为文件中的行号，csv表头为第1行
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ImportOrderResult struct {
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	DryRun   bool             `json:"dryRun"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+type importRow struct {
+	line  int
+	order *dao.TradeOrder
+}
+
+func (p *AddOrderParam) order() *dao.TradeOrder {
+	return &dao.TradeOrder{
+		UserId:         p.UserId,
+		Subject:        p.Subject,
+		TotalAmount:    p.TotalAmount,
+		DiscountAmount: p.DiscountAmount,
+		PaymentAmount:  p.PaymentAmount,
+		ExpireTime:     p.ExpireTime,
+		TradeStatus:    p.TradeStatus,
+		CreateUser:     p.CreateUser,
+	}
+}
+
+// setField 按列名设置csv中的字段，时间格式为 2006-01-02 15:04:05
+func (p *AddOrderParam) setField(name, value string) error {
+	var err error
+	switch name {
+	case "UserId":
+		p.UserId = value
+	case "Subject":
+		p.Subject = value
+	case "TotalAmount":
+		p.TotalAmount, err = strconv.ParseFloat(value, 64)
+	case "DiscountAmount":
+		p.DiscountAmount, err = strconv.ParseFloat(value, 64)
+	case "PaymentAmount":
+		p.PaymentAmount, err = strconv.ParseFloat(value, 64)
+	case "ExpireTime":
+		var t time.Time
+		if t, err = time.Parse("2006-01-02 15:04:05", value); err == nil {
+			p.ExpireTime = &dao.LocalTime{Time: t}
+		}
+	case "TradeStatus":
+		p.TradeStatus, err = strconv.Atoi(value)
+	case "CreateUser":
+		p.CreateUser = value
+	default:
+		return fmt.Errorf("不支持的列: %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s格式错误: %s", name, value)
+	}
+	return nil
+}
+
+// parseImport 逐行解析并校验，fn收到校验通过的订单，格式错误的行记入报告
+func parseImport(format string, r io.Reader, maxRows int, fn func(row importRow), report func(line int, err error)) (int, error) {
+	total := 0
+
+	accept := func(line int, p *AddOrderParam, err error) error {
+		total++
+		if total > maxRows {
+			return errImportTooLarge
+		}
+		if err == nil {
+			err = binding.Validator.ValidateStruct(p)
+		}
+		if err != nil {
+			report(line, err)
+			return nil
+		}
+		fn(importRow{line: line, order: p.order()})
+		return nil
+	}
+
+	if format == importFormatNdjson {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+
+			var p AddOrderParam
+			if err := accept(line, &p, json.Unmarshal(data, &p)); err != nil {
+				return total, err
+			}
+		}
+		return total, scanner.Err()
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("读取表头失败: %w", err)
+	}
+	for i, name := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !importColumns[header[i]] {
+			return 0, fmt.Errorf("不支持的列: %s", header[i])
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return total, err
+			}
+			if err := accept(parseErr.StartLine, nil, err); err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		var p AddOrderParam
+		if len(record) != len(header) {
+			err = fmt.Errorf("列数应为%d，实际为%d", len(header), len(record))
+		}
+		for i := 0; err == nil && i < len(record); i++ {
+			err = p.setField(header[i], strings.TrimSpace(record[i]))
+		}
+		if err := accept(line, &p, err); err != nil {
+			return total, err
+		}
+	}
+}
+
+// invalidateImportPages 一次递增导入涉及的全部用户的分页缓存版本号
+func (o *OrderHandler) invalidateImportPages(users map[string]struct{}) {
+	userIds := make([]string, 0, len(users))
+	for user := range users {
+		userIds = append(userIds, user)
+	}
+	o.invalidateOrderPages(userIds...)
+}
+
+// ImportOrder 批量导入csv或ndjson格式的订单，校验通过的行按批在事务中写入，返回逐行错误报告
+func (o *OrderHandler) ImportOrder(c *gin.Context) {
+
+	var params ImportOrderParam
+	if err := c.ShouldBindQuery(&params); err != nil {
+		o.logger.Debug("参数解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "参数解析异常"})
+		return
+	}
+	if params.Format == "" {
+		params.Format = importFormatCsv
+		if strings.Contains(c.ContentType(), "ndjson") {
+			params.Format = importFormatNdjson
+		}
+	}
+
+	result := &ImportOrderResult{DryRun: params.DryRun, Errors: []ImportRowError{}}
+	report := func(line int, err error) {
+		result.Errors = append(result.Errors, ImportRowError{Line: line, Error: err.Error()})
+	}
+
+	var rows []importRow
+	total, err := parseImport(params.Format, c.Request.Body, o.importMaxRows, func(row importRow) {
+		rows = append(rows, row)
+	}, report)
+	if errors.Is(err, errImportTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, Response[struct{}]{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("单次最多导入%d行", o.importMaxRows)})
+		return
+	}
+	if err != nil {
+		o.logger.Debug("导入文件解析异常", zap.Error(err))
+		c.JSON(http.StatusBadRequest, Response[struct{}]{Code: http.StatusBadRequest, Message: "导入文件解析异常"})
+		return
+	}
+	result.Total = total
+
+	users := make(map[string]struct{})
+	for start := 0; !params.DryRun && start < len(rows); start += o.importBatch {
+		end := start + o.importBatch
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		orders := make([]*dao.TradeOrder, end-start)
+		for i, row := range rows[start:end] {
+			orders[i] = row.order
+		}
+
+		// 同一批次在一个事务中写入，失败时整批记入报告
+		if err := o.orderDao.AddOrders(orders); err != nil {
+			for _, row := range rows[start:end] {
+				report(row.line, errors.New("写入订单失败"))
+			}
+			continue
+		}
+
+		result.Imported += len(orders)
+		for _, order := range orders {
+			users[order.UserId] = struct{}{}
+		}
+	}
+	result.Failed = len(result.Errors)
+	sort.Slice(result.Errors, func(i, j int) bool { return result.Errors[i].Line < result.Errors[j].Line })
+
+	if len(users) > 0 {
+		o.invalidateImportPages(users)
+	}
+
+	o.logger.Info("导入订单", zap.Int("total", result.Total), zap.Int("imported", result.Imported), zap.Int("failed", result.Failed), zap.Bool("dryRun", params.DryRun))
+	c.JSON(http.StatusOK, Response[*ImportOrderResult]{Code: http.StatusOK, Data: result})
+}
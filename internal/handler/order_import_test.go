@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goweb/internal/config"
+
+	"go.uber.org/zap"
+)
+
+func TestParseImport(t *testing.T) {
+
+	csvData := "\ufeffUserId,Subject,TotalAmount,DiscountAmount,PaymentAmount,ExpireTime,CreateUser\n" +
+		"1,手机,10,1,9,2022-06-15 12:00:00,admin\n" +
+		"abc,手机,10,1,9,2022-06-15 12:00:00,admin\n" +
+		"1,手机,10,20,9,2022-06-15 12:00:00,admin\n" +
+		"1,手机,10\n" +
+		"1,手机,ten,0,0,2022-06-15 12:00:00,admin\n"
+
+	ndjsonData := `{"UserId":"1","Subject":"手机","TotalAmount":"10","ExpireTime":"2022-06-15T12:00:00Z","CreateUser":"admin"}` + "\n" +
+		"\n" +
+		`{"UserId":"1","Subject":"手机","TotalAmount":"10","CreateUser":"admin"}` + "\n" +
+		`{"UserId":1}` + "\n"
+
+	cases := []struct {
+		format, data string
+		total        int
+		valid        []int
+		invalid      []int
+	}{
+		{importFormatCsv, csvData, 5, []int{2}, []int{3, 4, 5, 6}},
+		{importFormatNdjson, ndjsonData, 3, []int{1}, []int{3, 4}},
+	}
+
+	for _, c := range cases {
+		var valid, invalid []int
+		total, err := parseImport(c.format, strings.NewReader(c.data), 100, func(row importRow) {
+			valid = append(valid, row.line)
+		}, func(line int, err error) {
+			invalid = append(invalid, line)
+		})
+		if err != nil {
+			t.Fatal(c.format, err)
+		}
+
+		if total != c.total || !equalInts(valid, c.valid) || !equalInts(invalid, c.invalid) {
+			t.Error("解析结果不一致", c.format, total, valid, invalid)
+		}
+	}
+
+	if _, err := parseImport(importFormatCsv, strings.NewReader("UserId,Password\n"), 100, func(importRow) {}, func(int, error) {}); err == nil {
+		t.Error("不支持的列应返回错误")
+	}
+
+	if _, err := parseImport(importFormatNdjson, strings.NewReader(ndjsonData), 2, func(importRow) {}, func(int, error) {}); err != errImportTooLarge {
+		t.Error("超过行数限制应返回errImportTooLarge", err)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestImportOrderDryRun(t *testing.T) {
+
+	h := &OrderHandler{importBatch: defaultImportBatch, importMaxRows: defaultImportMaxRows, logger: zap.NewNop()}
+	r := NewRouter(config.ServerConfig{}, h, &AdminHandler{}, zap.NewNop())
+
+	body := "UserId,Subject,TotalAmount,ExpireTime,CreateUser\n1,手机,10,2022-06-15 12:00:00,admin\n2,,10,2022-06-15 12:00:00,admin\n"
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/order/import?dryRun=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	r.ServeHTTP(w, req)
+
+	want := `{"code":200,"message":"","data":{"total":2,"imported":0,"failed":1,"dryRun":true,"errors":[{"line":3,`
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), want) {
+		t.Error("试导入结果不一致", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/order/import?format=xml", strings.NewReader(body))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Error("非法参数响应不为400", w.Code)
+	}
+}
@@ -4,18 +4,24 @@ import (
 	"fmt"
 	"net/http"
 
+	"goweb/internal/config"
+
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-func NewRouter(orderHandler *OrderHandler, logger *zap.Logger) *gin.Engine {
+func NewRouter(server config.ServerConfig, orderHandler *OrderHandler, adminHandler *AdminHandler, logger *zap.Logger) *gin.Engine {
 	r := gin.New()
+	logger = logger.Named("handler")
 
 	r.Use(ginzap.Ginzap(logger, "2006/01/02 15:04:05.000", true))
 
 	r.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
 		if err, ok := recovered.(string); ok {
 			logger.Error("请求异常", zap.Any("error", recovered))
 			c.String(http.StatusInternalServerError, fmt.Sprintf("error: %s", err))
@@ -26,14 +32,32 @@ func NewRouter(orderHandler *OrderHandler, logger *zap.Logger) *gin.Engine {
 	order := r.Group("/order")
 	{
 		order.GET("", orderHandler.GetOrder)
+		order.GET("/stats", orderHandler.GetOrderStats)
+		order.GET("/export", orderHandler.ExportOrder)
+		order.POST("/exports", orderHandler.SubmitExport)
+		order.GET("/exports/:id", orderHandler.GetExport)
 		order.POST("/", orderHandler.AddOrder)
+		order.POST("/import", orderHandler.ImportOrder)
 		order.PUT("/", orderHandler.UpdateOrder)
-		order.DELETE("/", orderHandler.DeleteOrder)
+		order.DELETE("/:tradeNo", orderHandler.DeleteOrder)
+	}
+
+	admin := r.Group("/admin", AdminAuth(server.Admin))
+	{
+		admin.POST("/order/:tradeNo/restore", orderHandler.RestoreOrder)
+		admin.GET("/log/level", adminHandler.GetLogLevel)
+		admin.PUT("/log/level", adminHandler.SetLogLevel)
+		admin.GET("/cache/janitor", adminHandler.GetJanitorStats)
 	}
 
 	return r
 }
 
 func ProvideRouter() fx.Option {
-	return fx.Provide(NewOrderHandler, NewRouter)
+	return fx.Options(
+		fx.Provide(NewOrderHandler, NewAdminHandler, NewRouter),
+		fx.Invoke(func(store *config.Store, o *OrderHandler) {
+			store.Subscribe(o.reload, "cache.stats.ttl", "cache.order")
+		}),
+	)
 }
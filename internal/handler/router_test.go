@@ -4,24 +4,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"goweb/internal/cache"
+	"goweb/internal/config"
 	"goweb/internal/dao"
+	"goweb/internal/di"
+	"goweb/internal/export"
+	"goweb/internal/leader"
+	"goweb/internal/logging"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/confmap"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func prepare() (*koanf.Koanf, *zap.Logger, error) {
 
-	var k = koanf.New(".")
-	if err := k.Load(file.Provider("../../config/config.yaml"), yaml.Parser()); err != nil {
-		fmt.Printf("加载配置失败 %v", err)
+	k, err := di.InitConf(di.ConfOptions{})
+	if err != nil {
 		return nil, nil, err
 	}
 
@@ -35,13 +42,13 @@ func prepare() (*koanf.Koanf, *zap.Logger, error) {
 	return k, logger, nil
 }
 
-func di() []fx.Option {
-	return []fx.Option{fx.Provide(prepare), dao.ProvideOrderDao(), cache.ProvideCache(), ProvideRouter()}
+func modules() []fx.Option {
+	return []fx.Option{fx.Provide(prepare, config.NewStore, di.NewLogLevels), config.ProvideConfig(), dao.ProvideOrderDao(), cache.ProvideCache(), leader.ProvideElector(), export.ProvideJobManager(), ProvideRouter()}
 }
 
 func TestGetOrder(t *testing.T) {
 
-	ops := di()
+	ops := modules()
 	done := make(chan struct{})
 
 	ops = append(ops, fx.Invoke(func(r *gin.Engine) {
@@ -71,3 +78,208 @@ func TestGetOrder(t *testing.T) {
 	<-done
 
 }
+
+func TestAddOrderValidate(t *testing.T) {
+
+	r := NewRouter(config.ServerConfig{}, &OrderHandler{logger: zap.NewNop()}, &AdminHandler{}, zap.NewNop())
+
+	bodies := []string{
+		`{}`,
+		`{"UserId":"abc","Subject":"test","TotalAmount":"10","ExpireTime":"2022-06-15T12:00:00Z","CreateUser":"admin"}`,
+		`{"UserId":"1","Subject":"test","TotalAmount":"10","DiscountAmount":"20","ExpireTime":"2022-06-15T12:00:00Z","CreateUser":"admin"}`,
+	}
+
+	for _, body := range bodies {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/order/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Error("非法参数响应不为400", w.Code, body)
+		}
+	}
+}
+
+func TestUpdateOrderValidate(t *testing.T) {
+
+	r := NewRouter(config.ServerConfig{}, &OrderHandler{logger: zap.NewNop()}, &AdminHandler{}, zap.NewNop())
+
+	bodies := []string{
+		`{"TradeNo":"1536972017172901888","UpdateUser":"admin","Subject":"test"}`,
+		`{"TradeNo":"1536972017172901888","UpdateTime":"2022-06-15T12:00:00Z","UpdateUser":"admin"}`,
+		`{"TradeNo":"1536972017172901888","UpdateTime":"2022-06-15T12:00:00Z","UpdateUser":"admin","TotalAmount":"-1"}`,
+	}
+
+	for _, body := range bodies {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/order/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Error("非法参数响应不为400", w.Code, body)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+
+	r := NewRouter(config.ServerConfig{Admin: config.AdminConfig{Token: "secret"}}, &OrderHandler{logger: zap.NewNop()}, &AdminHandler{}, zap.NewNop())
+
+	for token, code := range map[string]int{"": http.StatusForbidden, "wrong": http.StatusForbidden, "secret": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/order/abc/restore", nil)
+		req.Header.Set(adminTokenHeader, token)
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != code {
+			t.Error("管理接口响应不一致", token, w.Code)
+		}
+	}
+}
+
+func TestGetOrderValidate(t *testing.T) {
+
+	r := NewRouter(config.ServerConfig{}, &OrderHandler{logger: zap.NewNop()}, &AdminHandler{}, zap.NewNop())
+
+	for _, query := range []string{"sort=Subject:asc", "createTimeFrom=2022-06-01", "tradeStatus=a", "cursor=abc&pageSize=10", "cursor=*"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/order?"+query, nil)
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Error("非法参数响应不为400", w.Code, query)
+		}
+	}
+
+	for _, url := range []string{"/order/exports?format=pdf", "/order/exports?columns=Password", "/order/exports?sort=Subject"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", url, nil)
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Error("非法参数响应不为400", w.Code, url)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/order/exports/not-a-job", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Error("非法任务编号响应不为400", w.Code)
+	}
+
+	for _, query := range []string{"interval=minute", "top=1000", "interval=day&sort=Subject"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/order/stats?"+query, nil)
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Error("非法参数响应不为400", w.Code, query)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+
+	levels := logging.NewLevels(zapcore.InfoLevel, map[string]zapcore.Level{"gorm": zapcore.WarnLevel})
+	r := NewRouter(config.ServerConfig{Admin: config.AdminConfig{Token: "secret"}}, &OrderHandler{logger: zap.NewNop()}, NewAdminHandler(levels, nil, zap.NewNop()), zap.NewNop())
+
+	for body, code := range map[string]int{
+		`{"level":"debug"}`:                 http.StatusOK,
+		`{"module":"cache","level":"warn"}`: http.StatusOK,
+		`{"module":"gorm"}`:                 http.StatusOK,
+		`{"level":"verbose"}`:               http.StatusBadRequest,
+		`{}`:                                http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/admin/log/level", strings.NewReader(body))
+		req.Header.Set(adminTokenHeader, "secret")
+		r.ServeHTTP(w, req)
+
+		if w.Code != code {
+			t.Error("修改日志级别响应不一致", body, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/log/level", nil)
+	req.Header.Set(adminTokenHeader, "secret")
+	r.ServeHTTP(w, req)
+
+	var resp Response[LogLevelResult]
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Level != "debug" || resp.Data.Modules["cache"] != "warn" || len(resp.Data.Modules) != 1 {
+		t.Error("日志级别不一致", resp.Data)
+	}
+}
+
+func TestAbortResponse(t *testing.T) {
+
+	r := gin.New()
+	r.GET("/export", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("TradeNo\n1\n")
+		c.Writer.Flush()
+		abortResponse(c)
+	})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Error("中断的响应被当作完整响应读取")
+	}
+}
+
+func TestOrderPageCodec(t *testing.T) {
+
+	k := koanf.New(".")
+	k.Load(confmap.Provider(map[string]any{"cache.order.codec": "protobuf"}, "."), nil)
+
+	// 订单不是protobuf消息，启动时失败
+	if _, err := NewOrderHandler(k, nil, nil, nil, zap.NewNop()); err == nil {
+		t.Error("订单分页缓存不支持protobuf")
+	}
+
+	k.Load(confmap.Provider(map[string]any{"cache.order.codec": "msgpack"}, "."), nil)
+	o, err := NewOrderHandler(k, nil, nil, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range map[string]string{"cache.order.codec": "protobuf", "cache.order.soft_ttl": "0s", "cache.stats.ttl": "-1s"} {
+		k.Load(confmap.Provider(map[string]any{"cache.order.codec": "msgpack", "cache.order.soft_ttl": "30s", "cache.stats.ttl": "1m", key: value}, "."), nil)
+		o.reload(k)
+		if name := o.orderPages.Load().Codec.Name(); name != "msgpack" {
+			t.Error("配置无效时应保留当前序列化方式", key, name)
+		}
+		if soft := o.orderPages.Load().Soft; soft != defaultPageSoftTTL {
+			t.Error("配置无效时应保留当前软过期时间", key, soft)
+		}
+		if ttl := time.Duration(o.statsTTL.Load()); ttl != defaultStatsTTL {
+			t.Error("配置无效时应保留当前统计缓存时间", key, ttl)
+		}
+	}
+
+	// 未知的序列化方式不再退回json
+	k.Load(confmap.Provider(map[string]any{"cache.order.codec": "gob", "cache.order.soft_ttl": "1m", "cache.stats.ttl": "1m"}, "."), nil)
+	if _, err := NewOrderHandler(k, nil, nil, nil, zap.NewNop()); err == nil {
+		t.Error("未知的序列化方式应启动失败")
+	}
+}
@@ -0,0 +1,290 @@
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/knadh/koanf"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultKey = "leader:{goweb}"
+	defaultTTL = 15 * time.Second
+)
+
+// renewScript 只续期自己持有的租约
+var renewScript = redis.NewScript(`if redis.call('GET',KEYS[1]) == ARGV[1] then
+    return redis.call('PEXPIRE',KEYS[1],ARGV[2])
+else
+    return 0
+end`)
+
+// resignScript 只释放自己持有的租约
+var resignScript = redis.NewScript(`if redis.call('GET',KEYS[1]) == ARGV[1] then
+    return redis.call('DEL',KEYS[1])
+else
+    return 0
+end`)
+
+// verifyScript 租约仍由自己持有且fencing token未被更新的leader取代
+var verifyScript = redis.NewScript(`if redis.call('GET',KEYS[1]) == ARGV[1] and redis.call('GET',KEYS[2]) == ARGV[2] then
+    return 1
+else
+    return 0
+end`)
+
+type tokenKey struct{}
+
+// TokenFrom 任务上下文中的fencing token，每次当选递增
+func TokenFrom(ctx context.Context) int64 {
+	token, _ := ctx.Value(tokenKey{}).(int64)
+	return token
+}
+
+type job struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+// Elector 基于redis租约的leader选举，当选后运行注册的任务，失去租约时取消任务
+type Elector struct {
+	rdb    redis.UniversalClient
+	key    string
+	id     string
+	ttl    time.Duration
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	jobs    []job
+	token   int64
+	renewed time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// withHashTag 租约与token在lua脚本中同时使用，cluster下需位于同一slot
+func withHashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 && strings.IndexByte(key[i+1:], '}') > 0 {
+		return key
+	}
+	return "{" + key + "}"
+}
+
+func (e *Elector) tokenKey() string {
+	return e.key + ":token"
+}
+
+// Run 注册只在leader上运行的任务，fn应在ctx取消后返回，重新当选时再次运行
+func (e *Elector) Run(name string, fn func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	j := job{name: name, fn: fn}
+	e.jobs = append(e.jobs, j)
+	if e.ctx != nil {
+		e.start(j)
+	}
+}
+
+// IsLeader 当前实例是否持有租约
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ctx != nil
+}
+
+// Verify 写入前确认token仍然有效，旧leader恢复后不会覆盖新leader的结果
+func (e *Elector) Verify(token int64) (bool, error) {
+	ret, err := verifyScript.Run(e.rdb, []string{e.key, e.tokenKey()}, e.id, token).Int64()
+	return ret == 1, err
+}
+
+// start 调用方持有mu
+func (e *Elector) start(j job) {
+	ctx, token := e.ctx, e.token
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		e.logger.Info("启动leader任务", zap.String("job", j.name), zap.Int64("token", token))
+		j.fn(ctx)
+	}()
+}
+
+func (e *Elector) elected(token int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.token = token
+	e.renewed = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	e.ctx, e.cancel = context.WithValue(ctx, tokenKey{}, token), cancel
+	for _, j := range e.jobs {
+		e.start(j)
+	}
+}
+
+// stepDown 取消任务并等待退出，之后其他实例才可能拿到租约
+func (e *Elector) stepDown() {
+	e.mu.Lock()
+	if e.ctx == nil {
+		e.mu.Unlock()
+		return
+	}
+	e.cancel()
+	e.ctx, e.cancel = nil, nil
+	e.mu.Unlock()
+
+	e.running.Wait()
+}
+
+func (e *Elector) tryAcquire() {
+	ok, err := e.rdb.SetNX(e.key, e.id, e.ttl).Result()
+	if err != nil {
+		e.logger.Warn("竞选leader失败", zap.Error(err))
+		return
+	}
+	if !ok {
+		return
+	}
+
+	token, err := e.rdb.Incr(e.tokenKey()).Result()
+	if err != nil {
+		e.logger.Warn("获取fencing token失败", zap.Error(err))
+		resignScript.Run(e.rdb, []string{e.key}, e.id)
+		return
+	}
+
+	e.logger.Info("当选leader", zap.String("id", e.id), zap.Int64("token", token))
+	e.elected(token)
+}
+
+func (e *Elector) renew() {
+	ret, err := renewScript.Run(e.rdb, []string{e.key}, e.id, e.ttl.Milliseconds()).Int64()
+	if err == nil && ret == 1 {
+		e.mu.Lock()
+		e.renewed = time.Now()
+		e.mu.Unlock()
+		return
+	}
+
+	if err == nil {
+		e.logger.Warn("leader租约已被其他实例持有", zap.String("id", e.id))
+		e.stepDown()
+		return
+	}
+
+	// redis暂时不可用，租约可能仍有效，超过ttl后才放弃
+	e.logger.Warn("续期leader租约失败", zap.Error(err))
+	e.mu.Lock()
+	expired := time.Since(e.renewed) >= e.ttl
+	e.mu.Unlock()
+	if expired {
+		e.stepDown()
+	}
+}
+
+// loop 每ttl的三分之一续期或竞选一次
+func (e *Elector) loop() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		if e.IsLeader() {
+			e.renew()
+		} else {
+			e.tryAcquire()
+		}
+
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resign 停止任务后释放租约，其他实例无需等待租约过期即可接任
+func (e *Elector) resign() {
+	leader := e.IsLeader()
+	e.stepDown()
+	if !leader {
+		return
+	}
+
+	if err := resignScript.Run(e.rdb, []string{e.key}, e.id).Err(); err != nil {
+		e.logger.Warn("释放leader租约失败", zap.Error(err))
+		return
+	}
+	e.logger.Info("释放leader租约", zap.String("id", e.id))
+}
+
+func newId() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(b)
+}
+
+func NewElector(k *koanf.Koanf, rdb redis.UniversalClient, lc fx.Lifecycle, logger *zap.Logger) *Elector {
+
+	e := &Elector{
+		rdb:    rdb,
+		key:    defaultKey,
+		id:     newId(),
+		ttl:    defaultTTL,
+		logger: logger.Named("leader"),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if k.Exists("leader.key") {
+		e.key = withHashTag(k.String("leader.key"))
+	}
+	if k.Exists("leader.ttl") {
+		e.ttl = k.Duration("leader.ttl")
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go e.loop()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(e.stop)
+			<-e.done
+
+			resigned := make(chan struct{})
+			go func() {
+				e.resign()
+				close(resigned)
+			}()
+
+			select {
+			case <-resigned:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+
+	return e
+}
+
+func ProvideElector() fx.Option {
+	return fx.Provide(NewElector)
+}
@@ -0,0 +1,66 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestElectorJobs(t *testing.T) {
+
+	e := &Elector{logger: zap.NewNop()}
+
+	started := make(chan int64, 2)
+	stopped := make(chan struct{}, 2)
+	e.Run("test", func(ctx context.Context) {
+		started <- TokenFrom(ctx)
+		<-ctx.Done()
+		stopped <- struct{}{}
+	})
+
+	select {
+	case <-started:
+		t.Fatal("未当选时不应运行任务")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	e.elected(3)
+	if !e.IsLeader() {
+		t.Error("当选后应为leader")
+	}
+	if token := <-started; token != 3 {
+		t.Error("任务上下文中的token不一致", token)
+	}
+
+	e.stepDown()
+	if e.IsLeader() {
+		t.Error("卸任后不应为leader")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("卸任时应等待任务退出")
+	}
+
+	// 重新当选时再次运行任务
+	e.elected(4)
+	if token := <-started; token != 4 {
+		t.Error("任务上下文中的token不一致", token)
+	}
+	e.stepDown()
+}
+
+func TestWithHashTag(t *testing.T) {
+
+	for key, want := range map[string]string{
+		"leader:goweb":   "{leader:goweb}",
+		"leader:{goweb}": "leader:{goweb}",
+		"leader:{}":      "{leader:{}}",
+	} {
+		if got := withHashTag(key); got != want {
+			t.Error("hash tag不正确", key, got)
+		}
+	}
+}
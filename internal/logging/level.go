@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Levels 全局及各模块的日志级别，模块为logger名称的第一段，如cache.janitor属于cache
+type Levels struct {
+	global zap.AtomicLevel
+
+	mu      sync.RWMutex
+	modules map[string]zapcore.Level
+}
+
+func NewLevels(global zapcore.Level, modules map[string]zapcore.Level) *Levels {
+	l := &Levels{global: zap.NewAtomicLevelAt(global)}
+	l.Apply(global, modules)
+	return l
+}
+
+// Apply 设置全局级别并替换全部模块级别
+func (l *Levels) Apply(global zapcore.Level, modules map[string]zapcore.Level) {
+	l.global.SetLevel(global)
+
+	copied := make(map[string]zapcore.Level, len(modules))
+	for module, level := range modules {
+		copied[module] = level
+	}
+
+	l.mu.Lock()
+	l.modules = copied
+	l.mu.Unlock()
+}
+
+func (l *Levels) Global() zapcore.Level {
+	return l.global.Level()
+}
+
+func (l *Levels) SetGlobal(level zapcore.Level) {
+	l.global.SetLevel(level)
+}
+
+// Modules 各模块单独设置的级别
+func (l *Levels) Modules() map[string]zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	modules := make(map[string]zapcore.Level, len(l.modules))
+	for module, level := range l.modules {
+		modules[module] = level
+	}
+	return modules
+}
+
+func (l *Levels) SetModule(module string, level zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules[module] = level
+}
+
+// ResetModule 删除模块级别，改用全局级别
+func (l *Levels) ResetModule(module string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.modules, module)
+}
+
+func module(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// Enabled 名为name的logger是否输出该级别
+func (l *Levels) Enabled(name string, level zapcore.Level) bool {
+	l.mu.RLock()
+	min, ok := l.modules[module(name)]
+	l.mu.RUnlock()
+
+	if !ok {
+		min = l.global.Level()
+	}
+	return level >= min
+}
+
+// lowest 全局及各模块中最低的级别，低于它的日志无需构造
+func (l *Levels) lowest() zapcore.Level {
+	min := l.global.Level()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, level := range l.modules {
+		if level < min {
+			min = level
+		}
+	}
+	return min
+}
+
+// levelCore 按logger名称过滤日志，内层core输出所有级别
+type levelCore struct {
+	zapcore.Core
+	levels *Levels
+}
+
+// NewCore inner不应再按级别过滤
+func NewCore(inner zapcore.Core, levels *Levels) zapcore.Core {
+	return &levelCore{Core: inner, levels: levels}
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return level >= c.levels.lowest()
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.Enabled(ent.LoggerName, ent.Level) {
+		return ce
+	}
+	return ce.AddCore(ent, c)
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLevels(t *testing.T) {
+
+	levels := NewLevels(zapcore.InfoLevel, map[string]zapcore.Level{"gorm": zapcore.WarnLevel, "cache": zapcore.DebugLevel})
+	inner, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(NewCore(inner, levels))
+
+	logger.Debug("global debug")
+	logger.Info("global info")
+	logger.Named("gorm").Info("gorm info")
+	logger.Named("gorm").Warn("gorm warn")
+	logger.Named("cache").Named("janitor").Debug("cache debug")
+	logger.With(zap.String("k", "v")).Named("dao").Debug("dao debug")
+
+	var got []string
+	for _, entry := range logs.All() {
+		got = append(got, entry.Message)
+	}
+	if len(got) != 3 || got[0] != "global info" || got[1] != "gorm warn" || got[2] != "cache debug" {
+		t.Error("按模块过滤日志不正确", got)
+	}
+
+	// 运行时修改
+	levels.SetGlobal(zapcore.DebugLevel)
+	levels.ResetModule("gorm")
+	logger.Named("gorm").Debug("gorm debug")
+	if logs.FilterMessage("gorm debug").Len() != 1 {
+		t.Error("修改日志级别未生效")
+	}
+}